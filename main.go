@@ -1,25 +1,62 @@
 package main
 
 import (
+	"flag"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
-	"github.com/kubeberth/kubeberth-apiserver/pkg/isoimages"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/archives"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/catalog"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/cloudinits"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/disks"
-	"github.com/kubeberth/kubeberth-apiserver/pkg/servers"
-	"github.com/kubeberth/kubeberth-apiserver/pkg/loadbalancers"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/export"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/healthz"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/instancetypes"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/isoimages"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/keypairs"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/loadbalancers"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/servers"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/storageclasses"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/upload"
 	clientset "github.com/kubeberth/kubeberth-operator/pkg/clientset/versioned"
 )
 
 func main() {
 	klog.InitFlags(nil)
+	instanceTypesFile := flag.String("instancetypes", "/etc/kubeberth/instancetypes.yaml", "path to the instance type catalog")
+	exportProxy := flag.String("export-proxy", "", "endpoint of the export server that reads disk images from volumes")
+	catalogSources := flag.String("catalog", "", "comma-separated list of image catalog index files or URLs")
+	uploadProxy := flag.String("upload-proxy", "", "endpoint of the upload server that writes disk images into volumes")
+	flag.Parse()
+
+	if err := instancetypes.Load(*instanceTypesFile); err != nil {
+		klog.Fatalf("loading instance types: %s", err.Error())
+	}
+
+	if *catalogSources != "" {
+		catalog.Sources = strings.Split(*catalogSources, ",")
+	}
+
+	if *uploadProxy != "" {
+		upload.DefaultProxy = upload.NewHTTPProxy(*uploadProxy, os.Getenv("UPLOAD_PROXY_TOKEN"))
+	}
+
+	if *exportProxy != "" {
+		export.DefaultProxy = export.NewHTTPProxy(*exportProxy, os.Getenv("EXPORT_PROXY_TOKEN"))
+	}
+
 	config, err := rest.InClusterConfig()
 
 	if err != nil {
@@ -29,34 +66,67 @@ func main() {
 		}
 	}
 
+	client.Config = config
 	client.Clientset, err = clientset.NewForConfig(config)
 	if err != nil {
 		klog.Fatalf("clientset.NewForConfig: %s", err.Error())
 		return
 	}
 
+	client.Kubernetes, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("kubernetes.NewForConfig: %s", err.Error())
+		return
+	}
+
+	client.Dynamic, err = dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("dynamic.NewForConfig: %s", err.Error())
+		return
+	}
+
+	// Verifications run in the replica that started them, so the ones lost
+	// with a replica are picked up again by the others.
+	go wait.Forever(func() {
+		archives.ResumeVerifications()
+		isoimages.ResumeVerifications()
+	}, checksum.StaleAfter)
+
 	g := gin.Default()
 	r := g.Group("/api/v1alpha1")
 
 	r.GET("/isoimages", isoimages.GetAllISOImages)
 	r.GET("/isoimages/", isoimages.GetAllISOImages)
 	r.GET("/isoimages/:name", isoimages.GetISOImage)
+	r.GET("/isoimages/:name/usage", isoimages.GetISOImageUsage)
 	r.POST("/isoimages", isoimages.CreateISOImage)
 	r.POST("/isoimages/", isoimages.CreateISOImage)
 	r.PUT("/isoimages/:name", isoimages.UpdateISOImage)
 	r.DELETE("/isoimages/:name", isoimages.DeleteISOImage)
+	r.POST("/isoimages/:name/verify", isoimages.VerifyISOImage)
 
 	r.GET("/archives", archives.GetAllArchives)
 	r.GET("/archives/", archives.GetAllArchives)
 	r.GET("/archives/:name", archives.GetArchive)
+	r.GET("/archives/:name/usage", archives.GetArchiveUsage)
 	r.POST("/archives", archives.CreateArchive)
 	r.POST("/archives/", archives.CreateArchive)
 	r.PUT("/archives/:name", archives.UpdateArchive)
 	r.DELETE("/archives/:name", archives.DeleteArchive)
+	r.POST("/archives/:name/verify", archives.VerifyArchive)
+
+	r.GET("/keypairs", keypairs.GetAllKeyPairs)
+	r.GET("/keypairs/", keypairs.GetAllKeyPairs)
+	r.GET("/keypairs/:name", keypairs.GetKeyPair)
+	r.POST("/keypairs", keypairs.CreateKeyPair)
+	r.POST("/keypairs/", keypairs.CreateKeyPair)
+	r.DELETE("/keypairs/:name", keypairs.DeleteKeyPair)
 
 	r.GET("/cloudinits", cloudinits.GetAllCloudInits)
 	r.GET("/cloudinits/", cloudinits.GetAllCloudInits)
 	r.GET("/cloudinits/:name", cloudinits.GetCloudInit)
+	r.GET("/cloudinits/:name/preview", cloudinits.PreviewCloudInit)
+	r.POST("/cloudinits/:name/render", cloudinits.RenderCloudInit)
 	r.POST("/cloudinits", cloudinits.CreateCloudInit)
 	r.POST("/cloudinits/", cloudinits.CreateCloudInit)
 	r.PUT("/cloudinits/:name", cloudinits.UpdateCloudInit)
@@ -69,14 +139,43 @@ func main() {
 	r.POST("/disks/", disks.CreateDisk)
 	r.PUT("/disks/:name", disks.UpdateDisk)
 	r.DELETE("/disks/:name", disks.DeleteDisk)
+	r.POST("/disks/:name/resize", disks.ResizeDisk)
+	r.GET("/disks/:name/snapshots", disks.GetAllSnapshots)
+	r.GET("/disks/:name/snapshots/:snapshot", disks.GetSnapshot)
+	r.POST("/disks/:name/snapshots", disks.CreateSnapshot)
+	r.DELETE("/disks/:name/snapshots/:snapshot", disks.DeleteSnapshot)
+	r.POST("/disks/:name/restore", disks.RestoreDisk)
+	r.GET("/disks/:name/upload", disks.GetUpload)
+	r.PUT("/disks/:name/upload", disks.UploadDisk)
+	r.GET("/disks/:name/export", disks.ExportDisk)
 
 	r.GET("/servers", servers.GetAllServers)
 	r.GET("/servers/", servers.GetAllServers)
 	r.GET("/servers/:name", servers.GetServer)
 	r.POST("/servers", servers.CreateServer)
 	r.POST("/servers/", servers.CreateServer)
+	r.POST("/servers:action", servers.ServerCollectionAction)
 	r.PUT("/servers/:name", servers.UpdateServer)
 	r.DELETE("/servers/:name", servers.DeleteServer)
+	r.POST("/servers/:name/resize", servers.ResizeServer)
+	r.POST("/servers/:name/clone", servers.CloneServer)
+	r.POST("/servers/:name/disks", servers.AttachDisk)
+	r.DELETE("/servers/:name/disks/:disk", servers.DetachDisk)
+	r.POST("/servers/:name/isoimage", servers.InsertISOImage)
+	r.DELETE("/servers/:name/isoimage", servers.EjectISOImage)
+
+	r.GET("/instancetypes", instancetypes.GetAllInstanceTypes)
+	r.GET("/instancetypes/", instancetypes.GetAllInstanceTypes)
+	r.GET("/instancetypes/:name", instancetypes.GetInstanceType)
+
+	r.GET("/catalog", catalog.GetAllEntries)
+	r.GET("/catalog/", catalog.GetAllEntries)
+	r.GET("/catalog/:id", catalog.GetEntry)
+	r.POST("/catalog/:id/import", catalog.ImportEntry)
+
+	r.GET("/storageclasses", storageclasses.GetAllStorageClasses)
+	r.GET("/storageclasses/", storageclasses.GetAllStorageClasses)
+	r.GET("/storageclasses/:name", storageclasses.GetStorageClass)
 
 	r.GET("/loadbalancers", loadbalancers.GetAllLoadBalancers)
 	r.GET("/loadbalancers/", loadbalancers.GetAllLoadBalancers)
@@ -86,6 +185,11 @@ func main() {
 	r.PUT("/loadbalancers/:name", loadbalancers.UpdateLoadBalancer)
 	r.DELETE("/loadbalancers/:name", loadbalancers.DeleteLoadBalancer)
 
+	r.GET("/operations", operations.GetAllOperations)
+	r.GET("/operations/", operations.GetAllOperations)
+	r.GET("/operations/:name", operations.GetOperation)
+	r.DELETE("/operations/:name", operations.DeleteOperation)
+
 	r.GET("/healthz", healthz.Healthz)
 	r.GET("/healthz/", healthz.Healthz)
 
@@ -2,46 +2,135 @@ package archives
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/probe"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
 type Archive struct {
-	Name       string `json:"name"       binding:"required"`
-	Repository string `json:"repository"`
+	Name         string                 `json:"name"       binding:"required"`
+	Repository   string                 `json:"repository"`
+	Checksum     *checksum.Spec         `json:"checksum,omitempty"`
+	Probe        *probe.Result          `json:"probe,omitempty"`
+	Verification *checksum.Status       `json:"verification,omitempty"`
+	UsedBy       []references.Reference `json:"used_by"`
 }
 
 func convertArchive2Archive(archive v1alpha1.Archive) *Archive {
 	ret := &Archive{
 		Name:       archive.ObjectMeta.Name,
 		Repository: archive.Spec.Repository,
+		UsedBy:     []references.Reference{},
+	}
+
+	ret.Checksum, ret.Verification = checksum.FromAnnotations(archive.ObjectMeta.Annotations)
+
+	if data, ok := archive.ObjectMeta.Annotations[berth.AnnotationProbe]; ok {
+		result := &probe.Result{}
+		if err := json.Unmarshal([]byte(data), result); err == nil {
+			ret.Probe = result
+		}
 	}
 
 	return ret
 }
 
+// probeRepository checks that the repository serves an image unless the caller
+// opted out with ?skipProbe=true, in which case the result is nil.
+func probeRepository(ctx *gin.Context, repository string) (*probe.Result, validation.ErrorList) {
+	var errs validation.ErrorList
+	if ctx.Query("skipProbe") == "true" {
+		return nil, errs
+	}
+
+	result := probe.Probe(ctx.Request.Context(), repository)
+	if !result.Reachable {
+		errs = append(errs, validation.NewFieldError("repository", "unreachable: "+result.Error))
+	}
+
+	return result, errs
+}
+
+// setProbeAnnotation records the probe result on the archive. A nil result
+// drops any result from a previous repository.
+func setProbeAnnotation(archive *v1alpha1.Archive, result *probe.Result) error {
+	if result == nil {
+		delete(archive.ObjectMeta.Annotations, berth.AnnotationProbe)
+		return nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	if archive.ObjectMeta.Annotations == nil {
+		archive.ObjectMeta.Annotations = map[string]string{}
+	}
+	archive.ObjectMeta.Annotations[berth.AnnotationProbe] = string(data)
+
+	return nil
+}
+
+func validateArchive(a *Archive) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", a.Name)...)
+	errs = append(errs, validation.ValidateRepository("repository", a.Repository)...)
+	errs = append(errs, checksum.Validate("checksum", a.Checksum)...)
+
+	return errs
+}
+
 func GetAllArchives(ctx *gin.Context) {
 	namespace := "kubeberth"
-	archives, err := client.Clientset.Archives().Archives(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
 
-	if err != nil || len(archives.Items) == 0 {
+	archives, err := client.Clientset.Archives().Archives(namespace).List(context.TODO(), opts)
+
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
-	var ret []*Archive
+	usage, err := references.ArchiveUsage(namespace)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := []*Archive{}
 	for _, archive := range archives.Items {
-		ret = append(ret, convertArchive2Archive(archive))
+		a := convertArchive2Archive(archive)
+		if refs, ok := usage[archive.ObjectMeta.Name]; ok {
+			a.UsedBy = refs
+		}
+		ret = append(ret, a)
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, archives.ListMeta))
 }
 
 func GetArchive(ctx *gin.Context) {
@@ -56,33 +145,114 @@ func GetArchive(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertArchive2Archive(*archive))
+	refs, err := references.DisksReferencingArchive(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := convertArchive2Archive(*archive)
+	ret.UsedBy = refs
+
+	ctx.JSON(http.StatusOK, ret)
 }
 
-func CreateArchive(ctx *gin.Context) {
-	var a Archive
-	if err := ctx.ShouldBindJSON(&a); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "request invalid: " + err.Error(),
+func GetArchiveUsage(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+
+	if _, err := client.Clientset.Archives().Archives(namespace).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
-	name := a.Name
-	namespace := "kubeberth"
-	repository := a.Repository
+	refs, err := references.DisksReferencingArchive(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
 
+	ctx.JSON(http.StatusOK, &references.Usage{
+		Name:   name,
+		UsedBy: refs,
+	})
+}
+
+// Create stores a validated archive along with its probe result and starts
+// verifying its checksum, if one was given.
+func Create(namespace string, a *Archive, result *probe.Result) (*Archive, error) {
 	archive := &v1alpha1.Archive{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
+			Name:      a.Name,
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.ArchiveSpec{
-			Repository: repository,
+			Repository: a.Repository,
 		},
 	}
 
+	if err := setProbeAnnotation(archive, result); err != nil {
+		return nil, err
+	}
+
+	pending := &checksum.Status{State: checksum.StatePending}
+	if err := checksum.SetAnnotations(&archive.ObjectMeta, a.Checksum, pending); err != nil {
+		return nil, err
+	}
+
 	ret, err := client.Clientset.Archives().Archives(namespace).Create(context.TODO(), archive, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// An archive whose verification could not even be recorded is not left
+	// behind for a request that reports failure.
+	response := convertArchive2Archive(*ret)
+	if err := startVerification(namespace, ret, response); err != nil {
+		if err := client.Clientset.Archives().Archives(namespace).Delete(context.TODO(), ret.ObjectMeta.Name, metav1.DeleteOptions{}); err != nil {
+			klog.Errorf("archive %s: rolling back: %s", ret.ObjectMeta.Name, err.Error())
+		}
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func CreateArchive(ctx *gin.Context) {
+	var a Archive
+	if err := ctx.ShouldBindJSON(&a); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validateArchive(&a); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	result, errs := probeRepository(ctx, a.Repository)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+			"probe":   result,
+		})
+		return
+	}
+
+	namespace := "kubeberth"
+	ret, err := Create(namespace, &a, result)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
@@ -90,7 +260,7 @@ func CreateArchive(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertArchive2Archive(*ret))
+	ctx.JSON(http.StatusOK, ret)
 }
 
 func UpdateArchive(ctx *gin.Context) {
@@ -102,6 +272,24 @@ func UpdateArchive(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateArchive(&a); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	result, errs := probeRepository(ctx, a.Repository)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+			"probe":   result,
+		})
+		return
+	}
+
 	name := a.Name
 	namespace := "kubeberth"
 	repository := a.Repository
@@ -114,12 +302,32 @@ func UpdateArchive(ctx *gin.Context) {
 		return
 	}
 
+	previous, _ := checksum.FromAnnotations(archive.ObjectMeta.Annotations)
+	verify := a.Checksum != nil && (repository != archive.Spec.Repository || !checksum.Equal(previous, a.Checksum))
+
 	spec := v1alpha1.ArchiveSpec{
 		Repository: repository,
 	}
 
 	archive.Spec = spec
 
+	if err := setProbeAnnotation(archive, result); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if a.Checksum == nil || verify {
+		pending := &checksum.Status{State: checksum.StatePending}
+		if err := checksum.SetAnnotations(&archive.ObjectMeta, a.Checksum, pending); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "update error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	ret, err := client.Clientset.Archives().Archives(namespace).Update(context.TODO(), archive, metav1.UpdateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -128,12 +336,49 @@ func UpdateArchive(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertArchive2Archive(*ret))
+	response := convertArchive2Archive(*ret)
+	response.UsedBy, err = references.DisksReferencingArchive(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if verify {
+		if err := startVerification(namespace, ret, response); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "verify error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
 
 func DeleteArchive(ctx *gin.Context) {
 	name := ctx.Param("name")
 	namespace := "kubeberth"
+
+	if ctx.Query("force") != "true" {
+		refs, err := references.DisksReferencingArchive(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if len(refs) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":       "archive " + name + " is still referenced",
+				"referenced_by": refs,
+			})
+			return
+		}
+	}
+
 	err := client.Clientset.Archives().Archives(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 
 	if err != nil {
@@ -0,0 +1,150 @@
+package archives
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+func archiveOwnerReference(archive *v1alpha1.Archive) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
+		APIVersion: berth.APIVersion,
+		Kind:       "Archive",
+		Name:       archive.ObjectMeta.Name,
+		UID:        archive.ObjectMeta.UID,
+	}
+}
+
+func saveVerification(namespace, name string) func(*checksum.Status) error {
+	return func(status *checksum.Status) error {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			archive, err := client.Clientset.Archives().Archives(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			changed, err := checksum.Apply(&archive.ObjectMeta, status)
+			if err != nil || !changed {
+				return err
+			}
+
+			_, err = client.Clientset.Archives().Archives(namespace).Update(context.TODO(), archive, metav1.UpdateOptions{})
+			return err
+		})
+	}
+}
+
+// startVerification hashes the archive's image in the background if it has a
+// checksum, and reports the initial status in ret.
+func startVerification(namespace string, archive *v1alpha1.Archive, ret *Archive) error {
+	if ret.Checksum == nil {
+		return nil
+	}
+
+	name := archive.ObjectMeta.Name
+	_, status, err := checksum.Start(namespace, "archives/"+name, archive.Spec.Repository, ret.Checksum, archiveOwnerReference(archive), saveVerification(namespace, name))
+	if err != nil {
+		// The archive is stored already, so the failure is recorded on it
+		// rather than failing the request.
+		status = &checksum.Status{
+			State:   checksum.StateFailed,
+			Message: "starting verification: " + err.Error(),
+		}
+
+		if err := saveVerification(namespace, name)(status); err != nil {
+			return err
+		}
+	}
+
+	ret.Verification = status
+	return nil
+}
+
+// ResumeVerifications starts again the verifications that were lost with the
+// apiserver replica running them, or that never got started.
+func ResumeVerifications() {
+	namespace := "kubeberth"
+	archives, err := client.Clientset.Archives().Archives(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("resuming verifications: %s", err.Error())
+		return
+	}
+
+	for i := range archives.Items {
+		archive := &archives.Items[i]
+		ret := convertArchive2Archive(*archive)
+		stalled, err := checksum.Stalled(namespace, ret.Verification)
+		if err != nil {
+			klog.Errorf("archive %s: checking verification: %s", archive.ObjectMeta.Name, err.Error())
+			continue
+		}
+
+		if !stalled {
+			continue
+		}
+
+		klog.Infof("archive %s: resuming verification", archive.ObjectMeta.Name)
+		if err := startVerification(namespace, archive, ret); err != nil {
+			klog.Errorf("archive %s: resuming verification: %s", archive.ObjectMeta.Name, err.Error())
+		}
+	}
+}
+
+// VerifyArchive verifies the image again against the checksum it already has,
+// for instance after the mirror was fixed.
+func VerifyArchive(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	archive, err := client.Clientset.Archives().Archives(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := convertArchive2Archive(*archive)
+	if ret.Checksum == nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  validation.ErrorList{validation.NewFieldError("checksum", "archive "+name+" has no checksum to verify")},
+		})
+		return
+	}
+
+	stalled, err := checksum.Stalled(namespace, ret.Verification)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if ret.Verification != nil && ret.Verification.State == checksum.StateVerifying && !stalled {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message":      "archive " + name + " is being verified already",
+			"verification": ret.Verification,
+		})
+		return
+	}
+
+	if err := startVerification(namespace, archive, ret); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, ret)
+}
@@ -0,0 +1,64 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+)
+
+var (
+	ErrUnauthenticated = errors.New("a valid Kubernetes bearer token is required")
+	ErrForbidden       = errors.New("not allowed to read the secret")
+)
+
+// CanGetSecret checks that the caller's own Kubernetes identity, given as a
+// bearer token, may read the named secret. The apiserver's service account can
+// read every secret it manages, so this is what stands between its API and the
+// data in them. The check is a SelfSubjectAccessReview made with the caller's
+// token, which every authenticated user may create.
+func CanGetSecret(ctx *gin.Context, namespace, name string) error {
+	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if token == "" || token == ctx.GetHeader("Authorization") {
+		return ErrUnauthenticated
+	}
+
+	config := rest.AnonymousClientConfig(client.Config)
+	config.BearerToken = token
+	caller, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	review, err := caller.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Resource:  "secrets",
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsUnauthorized(err) {
+		return ErrUnauthenticated
+	}
+	if err != nil {
+		return err
+	}
+
+	if !review.Status.Allowed {
+		return ErrForbidden
+	}
+
+	return nil
+}
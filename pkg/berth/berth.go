@@ -1,14 +1,43 @@
 package berth
 
 import (
-	corev1 "k8s.io/api/core/v1"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 )
 
-type AttachedISOImage  = v1alpha1.AttachedISOImage
-type AttachedArchive   = v1alpha1.AttachedArchive
+type AttachedISOImage = v1alpha1.AttachedISOImage
+type AttachedArchive = v1alpha1.AttachedArchive
 type AttachedCloudInit = v1alpha1.AttachedCloudInit
-type AttachedDisk      = v1alpha1.AttachedDisk
-type AttachedSource    = v1alpha1.AttachedSource
-type Destination       = v1alpha1.Destination
-type Port              = corev1.ServicePort
+type AttachedDisk = v1alpha1.AttachedDisk
+type AttachedSource = v1alpha1.AttachedSource
+type Destination = v1alpha1.Destination
+type Port = corev1.ServicePort
+
+const (
+	APIVersion = "berth.kubeberth.io/v1alpha1"
+
+	LabelServer = "kubeberth.io/server"
+	LabelDisk   = "kubeberth.io/disk"
+
+	AnnotationInstanceType    = "kubeberth.io/instance-type"
+	AnnotationUploadState     = "kubeberth.io/upload-state"
+	AnnotationUploadFormat    = "kubeberth.io/upload-format"
+	AnnotationUploadOffset    = "kubeberth.io/upload-offset"
+	AnnotationUploadHashState = "kubeberth.io/upload-sha256-state"
+	AnnotationUploadSHA256    = "kubeberth.io/upload-sha256"
+	AnnotationProbe           = "kubeberth.io/probe"
+	AnnotationChecksum        = "kubeberth.io/checksum"
+	AnnotationChecksumStatus  = "kubeberth.io/checksum-status"
+	AnnotationSourceSnapshot  = "kubeberth.io/source-snapshot"
+	AnnotationStorageClass    = "kubeberth.io/storage-class"
+	AnnotationVolumeMode      = "kubeberth.io/volume-mode"
+	AnnotationAccessMode      = "kubeberth.io/access-mode"
+
+	AnnotationCloudInitTemplate      = "kubeberth.io/cloudinit-template"
+	AnnotationCloudInitVariables     = "kubeberth.io/cloudinit-variables"
+	AnnotationCloudInitSecret        = "kubeberth.io/cloudinit-secret"
+	AnnotationCloudInitSecretSources = "kubeberth.io/cloudinit-secret-sources"
+	AnnotationKeyPairs               = "kubeberth.io/keypairs"
+	AnnotationCloudInitParts         = "kubeberth.io/cloudinit-parts"
+	AnnotationCloudInitMerge         = "kubeberth.io/cloudinit-merge"
+)
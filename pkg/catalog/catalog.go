@@ -0,0 +1,396 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/archives"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/isoimages"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+const (
+	KindArchive  = "archive"
+	KindISOImage = "isoimage"
+
+	ttl = 5 * time.Minute
+
+	// fetchTimeout bounds a refresh of all the sources.
+	fetchTimeout = 2 * time.Minute
+)
+
+// Entry is an image offered by one of the catalog sources.
+type Entry struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	OS         string `json:"os"`
+	Release    string `json:"release"`
+	Arch       string `json:"arch"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256,omitempty"`
+	SHA512     string `json:"sha512,omitempty"`
+	Source     string `json:"source"`
+}
+
+type RequestImport struct {
+	Name string `json:"name"`
+}
+
+type ResponseImport struct {
+	Entry    *Entry                      `json:"entry"`
+	Archive  *archives.Archive           `json:"archive,omitempty"`
+	ISOImage *isoimages.ResponseISOImage `json:"isoimage,omitempty"`
+}
+
+// index is the custom format: {"images": [entry, ...]}.
+type index struct {
+	Images []*Entry `json:"images"`
+}
+
+var (
+	// Sources are index files, either local paths or http(s) URLs.
+	Sources []string
+
+	Client = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	mu         sync.Mutex
+	entries    []*Entry
+	bySource   map[string][]*Entry
+	loadedAt   time.Time
+	refreshing bool
+)
+
+var invalidIDChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slug(parts ...string) string {
+	id := invalidIDChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	return strings.Trim(id, "-")
+}
+
+func read(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func parse(source string, data []byte) ([]*Entry, error) {
+	var products streamProducts
+	if err := json.Unmarshal(data, &products); err == nil && strings.HasPrefix(products.Format, "products:") {
+		return parseStreams(source, &products), nil
+	}
+
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+
+	for i, e := range idx.Images {
+		if e.ID == "" {
+			e.ID = slug(e.OS, e.Release, e.Arch)
+		}
+
+		if e.Name == "" {
+			e.Name = e.ID
+		}
+
+		if e.Kind == "" {
+			e.Kind = KindArchive
+		}
+
+		if e.Kind != KindArchive && e.Kind != KindISOImage {
+			return nil, fmt.Errorf("images[%d]: unknown kind %s", i, e.Kind)
+		}
+
+		if e.Repository == "" {
+			return nil, fmt.Errorf("images[%d]: repository must not be empty", i)
+		}
+	}
+
+	return idx.Images, nil
+}
+
+// load returns the cached entries, reading the sources again once they expire.
+// The sources are read without holding the lock; while one request refreshes
+// the catalog, others are served the expired entries. The refresh is not tied
+// to the request that started it, since its result is served to every request
+// until it expires.
+func load() []*Entry {
+	mu.Lock()
+	current := entries
+	previous := bySource
+	if current != nil && (refreshing || time.Since(loadedAt) < ttl) {
+		mu.Unlock()
+		return current
+	}
+	refreshing = true
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	fetched := fetch(ctx, previous)
+	ret := merge(fetched)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	bySource = fetched
+	entries = ret
+	loadedAt = time.Now()
+	refreshing = false
+	return ret
+}
+
+// fetch reads every source. Sources that fail are logged and keep the entries
+// they had in previous, so one broken mirror does not empty the catalog.
+func fetch(ctx context.Context, previous map[string][]*Entry) map[string][]*Entry {
+	ret := map[string][]*Entry{}
+	for _, source := range Sources {
+		parsed, err := fetchSource(ctx, source)
+		if err != nil {
+			klog.Errorf("catalog: %s; keeping %d previous entries", err.Error(), len(previous[source]))
+			if entries, ok := previous[source]; ok {
+				ret[source] = entries
+			}
+			continue
+		}
+
+		for _, e := range parsed {
+			e.Source = source
+		}
+		ret[source] = parsed
+	}
+
+	return ret
+}
+
+func fetchSource(ctx context.Context, source string) ([]*Entry, error) {
+	data, err := read(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", source, err.Error())
+	}
+
+	parsed, err := parse(source, data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", source, err.Error())
+	}
+
+	return parsed, nil
+}
+
+// merge lists the entries in the order of the sources, keeping the first entry
+// for each ID.
+func merge(bySource map[string][]*Entry) []*Entry {
+	seen := map[string]bool{}
+	ret := []*Entry{}
+	for _, source := range Sources {
+		for _, e := range bySource[source] {
+			if seen[e.ID] {
+				continue
+			}
+			seen[e.ID] = true
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+func find(id string) (*Entry, bool) {
+	for _, e := range load() {
+		if e.ID == id {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+func (e *Entry) checksum() *checksum.Spec {
+	switch {
+	case e.SHA256 != "":
+		return &checksum.Spec{SHA256: e.SHA256}
+	case e.SHA512 != "":
+		return &checksum.Spec{SHA512: e.SHA512}
+	}
+
+	return nil
+}
+
+// isoImageSize rounds the image size up to whole GiB for the volume it is
+// copied into.
+func isoImageSize(size int64) string {
+	const gi = 1 << 30
+	return resource.NewQuantity((size+gi-1)/gi*gi, resource.BinarySI).String()
+}
+
+func GetAllEntries(ctx *gin.Context) {
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	ret := []*Entry{}
+	for _, e := range load() {
+		if os := ctx.Query("os"); os != "" && e.OS != os {
+			continue
+		}
+
+		if arch := ctx.Query("arch"); arch != "" && e.Arch != arch {
+			continue
+		}
+
+		if kind := ctx.Query("kind"); kind != "" && e.Kind != kind {
+			continue
+		}
+
+		ret = append(ret, e)
+	}
+
+	page, meta, errs := lists.Page(ret, opts)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(page, meta))
+}
+
+func GetEntry(ctx *gin.Context) {
+	id := ctx.Param("id")
+	e, ok := find(id)
+
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "error: catalog entry " + id + " not found",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, e)
+}
+
+// ImportEntry creates the archive or ISO image for a catalog entry, named after
+// the entry unless the request gives a name.
+func ImportEntry(ctx *gin.Context) {
+	var r RequestImport
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&r); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "request invalid: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	id := ctx.Param("id")
+	e, ok := find(id)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "error: catalog entry " + id + " not found",
+		})
+		return
+	}
+
+	name := r.Name
+	if name == "" {
+		name = e.ID
+	}
+
+	errs := validation.ValidateName("name", name)
+	errs = append(errs, validation.ValidateRepository("repository", e.Repository)...)
+	errs = append(errs, checksum.Validate("checksum", e.checksum())...)
+	if e.Kind == KindISOImage && e.Size <= 0 {
+		errs = append(errs, validation.NewFieldError("size", "catalog entry has no size"))
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	namespace := "kubeberth"
+	ret := &ResponseImport{
+		Entry: e,
+	}
+
+	var err error
+	switch e.Kind {
+	case KindArchive:
+		ret.Archive, err = archives.Create(namespace, &archives.Archive{
+			Name:       name,
+			Repository: e.Repository,
+			Checksum:   e.checksum(),
+		}, nil)
+	case KindISOImage:
+		ret.ISOImage, err = isoimages.Create(namespace, &isoimages.RequestISOImage{
+			Name:       name,
+			Size:       isoImageSize(e.Size),
+			Repository: e.Repository,
+			Checksum:   e.checksum(),
+		})
+	}
+
+	if apierrors.IsAlreadyExists(err) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, ret)
+}
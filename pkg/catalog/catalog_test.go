@@ -0,0 +1,233 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const streamsDocument = `{
+  "format": "products:1.0",
+  "products": {
+    "com.ubuntu.cloud:server:20.04:amd64": {
+      "os": "ubuntu", "release": "focal", "release_title": "20.04 LTS", "version": "20.04", "arch": "amd64",
+      "versions": {
+        "20220301": {"items": {"disk1.img": {"ftype": "disk1.img", "path": "server/focal/20220301/focal-amd64.img", "size": 100, "sha256": "old"}}},
+        "20220404.1": {"items": {"manifest": {"ftype": "manifest", "path": "server/focal/20220404.1/focal.manifest"}}},
+        "20220404": {"items": {
+          "kvm": {"ftype": "disk-kvm.img", "path": "server/focal/20220404/focal-kvm.img", "size": 300},
+          "disk1.img": {"ftype": "disk1.img", "path": "/server/focal/20220404/focal-amd64.img", "size": 200, "sha256": "new"}
+        }}
+      }
+    },
+    "com.ubuntu.cloud.daily:server:20.04:amd64": {
+      "os": "ubuntu", "release": "focal", "release_title": "20.04 LTS", "version": "20.04", "arch": "amd64",
+      "versions": {
+        "20220410": {"items": {"disk1.img": {"ftype": "disk1.img", "path": "server/daily/focal-amd64.img", "size": 400}}}
+      }
+    },
+    "com.ubuntu.cloud:server:22.04:arm64": {
+      "os": "ubuntu", "release": "jammy", "version": "22.04", "arch": "arm64",
+      "versions": {
+        "20220420": {"items": {"iso": {"ftype": "iso", "path": "server/jammy/jammy-arm64.iso", "size": 500}}}
+      }
+    },
+    "com.ubuntu.cloud:server:18.04:amd64": {
+      "os": "ubuntu", "release": "bionic", "version": "18.04", "arch": "amd64",
+      "versions": {
+        "20220101": {"items": {"manifest": {"ftype": "manifest", "path": "server/bionic.manifest"}}}
+      }
+    }
+  }
+}`
+
+func TestParseStreams(t *testing.T) {
+	source := "https://cloud-images.ubuntu.com/releases/streams/v1/com.ubuntu.cloud:released:download.json"
+
+	var products streamProducts
+	if err := json.Unmarshal([]byte(streamsDocument), &products); err != nil {
+		t.Fatal(err)
+	}
+
+	first := parseStreams(source, &products)
+
+	type summary struct {
+		ID, Kind, Version, Repository string
+	}
+	got := []summary{}
+	for _, e := range first {
+		got = append(got, summary{e.ID, e.Kind, e.Version, e.Repository})
+	}
+
+	root := "https://cloud-images.ubuntu.com/releases/"
+	want := []summary{
+		{"ubuntu-20-04-amd64", KindArchive, "20220410", root + "server/daily/focal-amd64.img"},
+		{"ubuntu-20-04-amd64", KindArchive, "20220404", root + "server/focal/20220404/focal-amd64.img"},
+		{"ubuntu-22-04-arm64", KindISOImage, "20220420", root + "server/jammy/jammy-arm64.iso"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStreams =\n%v\nwant\n%v", got, want)
+	}
+
+	// Map iteration order must not leak into the result.
+	for i := 0; i < 20; i++ {
+		if again := parseStreams(source, &products); !reflect.DeepEqual(again, first) {
+			t.Fatalf("parseStreams is not deterministic")
+		}
+	}
+}
+
+func TestFetchKeepsFirstEntryPerID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "index.json")
+	index := `{"images": [
+	  {"os": "debian", "release": "11", "arch": "amd64", "repository": "https://example.com/debian-11.qcow2"},
+	  {"id": "ubuntu-20-04-amd64", "repository": "https://example.com/focal.img"}
+	]}`
+	if err := ioutil.WriteFile(local, []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/streams/v1/index.json":
+			w.Write([]byte(streamsDocument))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(sources []string) { Sources = sources }(Sources)
+	Sources = []string{local, server.URL + "/missing.json", server.URL + "/streams/v1/index.json"}
+
+	entries := merge(fetch(context.Background(), nil))
+
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	want := []string{"debian-11-amd64", "ubuntu-20-04-amd64", "ubuntu-22-04-arm64"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("fetch IDs = %v, want %v", ids, want)
+	}
+
+	if entries[1].Source != local || entries[1].Repository != "https://example.com/focal.img" {
+		t.Errorf("ubuntu-20-04-amd64 came from %s (%s), want the first source", entries[1].Source, entries[1].Repository)
+	}
+
+	if entries[2].Source != server.URL+"/streams/v1/index.json" {
+		t.Errorf("ubuntu-22-04-arm64 source = %s", entries[2].Source)
+	}
+}
+
+// TestLoadDoesNotBlockOnRefresh checks that a slow source only delays the
+// request refreshing the catalog.
+func TestLoadDoesNotBlockOnRefresh(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		w.Write([]byte(`{"images": [{"id": "fresh", "repository": "https://example.com/fresh.img"}]}`))
+	}))
+	defer server.Close()
+
+	defer func(sources []string) {
+		Sources = sources
+		entries = nil
+		bySource = nil
+		loadedAt = time.Time{}
+	}(Sources)
+	Sources = []string{server.URL}
+
+	stale := []*Entry{{ID: "stale"}}
+	entries = stale
+	loadedAt = time.Now().Add(-2 * ttl)
+
+	refreshed := make(chan []*Entry)
+	go func() {
+		refreshed <- load()
+	}()
+	<-requested
+
+	done := make(chan []*Entry)
+	go func() {
+		done <- load()
+	}()
+
+	select {
+	case ret := <-done:
+		if len(ret) != 1 || ret[0].ID != "stale" {
+			t.Errorf("load during a refresh = %v, want the stale entries", ret)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("load blocked while another request refreshed the catalog")
+	}
+
+	close(release)
+	if ret := <-refreshed; len(ret) != 1 || ret[0].ID != "fresh" {
+		t.Errorf("refreshing load = %v, want the fresh entries", ret)
+	}
+
+	if ret := load(); len(ret) != 1 || ret[0].ID != "fresh" {
+		t.Errorf("load after the refresh = %v, want the fresh entries", ret)
+	}
+}
+
+// TestLoadKeepsFailedSources checks that a source failing on refresh keeps
+// the entries it had, while the others are refreshed.
+func TestLoadKeepsFailedSources(t *testing.T) {
+	failing := false
+	version := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken.json" && failing {
+			http.Error(w, "mirror down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"images": [{"id": "` + r.URL.Path[1:] + `-` + version + `", "repository": "https://example.com/image.img"}]}`))
+	}))
+	defer server.Close()
+
+	defer func(sources []string) {
+		Sources = sources
+		entries = nil
+		bySource = nil
+		loadedAt = time.Time{}
+	}(Sources)
+	Sources = []string{server.URL + "/broken.json", server.URL + "/working.json"}
+
+	ids := func(entries []*Entry) []string {
+		ret := []string{}
+		for _, e := range entries {
+			ret = append(ret, e.ID)
+		}
+		return ret
+	}
+
+	if got, want := ids(load()), []string{"broken.json-1", "working.json-1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first load = %v, want %v", got, want)
+	}
+
+	failing = true
+	version = "2"
+	loadedAt = time.Now().Add(-2 * ttl)
+
+	if got, want := ids(load()), []string{"broken.json-1", "working.json-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("load with a failing source = %v, want %v", got, want)
+	}
+}
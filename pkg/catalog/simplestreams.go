@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// simplestreams "products:1.0" documents, as published by cloud-images.ubuntu.com
+// under streams/v1/. Item paths are relative to the mirror root.
+type streamProducts struct {
+	Format   string                   `json:"format"`
+	Products map[string]streamProduct `json:"products"`
+}
+
+type streamProduct struct {
+	OS           string                   `json:"os"`
+	Release      string                   `json:"release"`
+	ReleaseTitle string                   `json:"release_title"`
+	Version      string                   `json:"version"`
+	Arch         string                   `json:"arch"`
+	Versions     map[string]streamVersion `json:"versions"`
+}
+
+type streamVersion struct {
+	Items map[string]streamItem `json:"items"`
+}
+
+type streamItem struct {
+	FileType string `json:"ftype"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// Preferred item types, best first: the qcow2 cloud image, the KVM-optimised
+// one, then installer ISOs.
+var streamFileTypes = []struct {
+	fileType string
+	kind     string
+}{
+	{"disk1.img", KindArchive},
+	{"disk-kvm.img", KindArchive},
+	{"img", KindArchive},
+	{"iso", KindISOImage},
+}
+
+func mirrorRoot(source string) string {
+	if i := strings.Index(source, "/streams/"); i >= 0 {
+		return source[:i+1]
+	}
+
+	return source[:strings.LastIndex(source, "/")+1]
+}
+
+// parseStreams returns one entry per product for its latest version that has
+// a usable item. Entries are sorted by ID, newest version first, so that the
+// same document always yields the same entries in the same order.
+func parseStreams(source string, products *streamProducts) []*Entry {
+	root := mirrorRoot(source)
+
+	names := make([]string, 0, len(products.Products))
+	for name := range products.Products {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []*Entry
+	for _, name := range names {
+		product := products.Products[name]
+
+		// Serials are dates such as 20220404 or 20220404.1.
+		serials := make([]string, 0, len(product.Versions))
+		for serial := range product.Versions {
+			serials = append(serials, serial)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(serials)))
+
+		var item streamItem
+		var kind, serial string
+		for _, s := range serials {
+			var ok bool
+			if item, kind, ok = preferredItem(product.Versions[s].Items); ok {
+				serial = s
+				break
+			}
+		}
+
+		if serial == "" {
+			continue
+		}
+
+		version := product.Version
+		if version == "" {
+			version = product.Release
+		}
+
+		title := product.ReleaseTitle
+		if title == "" {
+			title = product.Release
+		}
+
+		ret = append(ret, &Entry{
+			ID:         slug(product.OS, version, product.Arch),
+			Name:       strings.TrimSpace(product.OS + " " + title + " " + product.Arch),
+			Kind:       kind,
+			OS:         product.OS,
+			Release:    product.Release,
+			Arch:       product.Arch,
+			Version:    serial,
+			Repository: root + strings.TrimPrefix(item.Path, "/"),
+			Size:       item.Size,
+			SHA256:     item.SHA256,
+		})
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].ID != ret[j].ID {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].Version > ret[j].Version
+	})
+
+	return ret
+}
+
+func preferredItem(items map[string]streamItem) (streamItem, string, bool) {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, t := range streamFileTypes {
+		for _, key := range keys {
+			if items[key].FileType == t.fileType {
+				return items[key], t.kind, true
+			}
+		}
+	}
+
+	return streamItem{}, "", false
+}
@@ -0,0 +1,426 @@
+package checksum
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+const (
+	StatePending   = "Pending"
+	StateVerifying = "Verifying"
+	StateVerified  = "Verified"
+	StateMismatch  = "Mismatch"
+	StateFailed    = "Failed"
+
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+)
+
+var (
+	// Client has no overall timeout because images are hashed in full;
+	// IdleTimeout bounds how long a download may stall instead.
+	Client = &http.Client{}
+
+	// IdleTimeout cancels a download that has received nothing for that
+	// long, so that a stalled mirror does not hold a verification slot.
+	IdleTimeout = 2 * time.Minute
+
+	// HeartbeatInterval is how often a running verification updates its
+	// operation. One not updated for StaleAfter is taken to have been lost
+	// with the apiserver replica running it, and is resumed.
+	HeartbeatInterval = time.Minute
+	StaleAfter        = 3 * HeartbeatInterval
+)
+
+// maxVerifications bounds how many images are hashed at once. Further
+// verifications wait in the "queued" step until a slot is free.
+const maxVerifications = 4
+
+var verifications = make(chan struct{}, maxVerifications)
+
+// Spec is the expected checksum of an image, given either directly or as a
+// SHA256SUMS file listing the image by its file name.
+type Spec struct {
+	SHA256     string `json:"sha256,omitempty"`
+	SHA512     string `json:"sha512,omitempty"`
+	SHA256Sums string `json:"sha256sums,omitempty"`
+}
+
+type Status struct {
+	State      string `json:"state"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Operation  string `json:"operation,omitempty"`
+	VerifiedAt string `json:"verified_at,omitempty"`
+}
+
+func Validate(field string, spec *Spec) validation.ErrorList {
+	var errs validation.ErrorList
+	if spec == nil {
+		return errs
+	}
+
+	set := 0
+	for _, value := range []string{spec.SHA256, spec.SHA512, spec.SHA256Sums} {
+		if value != "" {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return append(errs, validation.NewFieldError(field, "exactly one of sha256, sha512 or sha256sums must be specified"))
+	}
+
+	if spec.SHA256 != "" {
+		errs = append(errs, validateDigest(validation.Child(field, "sha256"), spec.SHA256, sha256.Size)...)
+	}
+
+	if spec.SHA512 != "" {
+		errs = append(errs, validateDigest(validation.Child(field, "sha512"), spec.SHA512, sha512.Size)...)
+	}
+
+	if spec.SHA256Sums != "" {
+		errs = append(errs, validation.ValidateRepository(validation.Child(field, "sha256sums"), spec.SHA256Sums)...)
+	}
+
+	return errs
+}
+
+func validateDigest(field, digest string, size int) validation.ErrorList {
+	var errs validation.ErrorList
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != size*2 {
+		errs = append(errs, validation.NewFieldError(field, fmt.Sprintf("must be %d hex characters", size*2)))
+	}
+
+	return errs
+}
+
+func Equal(a, b *Spec) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return strings.EqualFold(a.SHA256, b.SHA256) && strings.EqualFold(a.SHA512, b.SHA512) && a.SHA256Sums == b.SHA256Sums
+}
+
+// FromAnnotations returns the checksum and verification status recorded on an
+// archive or ISO image, or nil when there is none.
+func FromAnnotations(annotations map[string]string) (*Spec, *Status) {
+	var spec *Spec
+	var status *Status
+
+	if data, ok := annotations[berth.AnnotationChecksum]; ok {
+		spec = &Spec{}
+		if err := json.Unmarshal([]byte(data), spec); err != nil {
+			spec = nil
+		}
+	}
+
+	if data, ok := annotations[berth.AnnotationChecksumStatus]; ok {
+		status = &Status{}
+		if err := json.Unmarshal([]byte(data), status); err != nil {
+			status = nil
+		}
+	}
+
+	return spec, status
+}
+
+// SetAnnotations records the checksum and status on meta. A nil spec removes both.
+func SetAnnotations(meta *metav1.ObjectMeta, spec *Spec, status *Status) error {
+	if spec == nil {
+		delete(meta.Annotations, berth.AnnotationChecksum)
+		delete(meta.Annotations, berth.AnnotationChecksumStatus)
+		return nil
+	}
+
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	meta.Annotations[berth.AnnotationChecksum] = string(data)
+
+	if status != nil {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		meta.Annotations[berth.AnnotationChecksumStatus] = string(data)
+	}
+
+	return nil
+}
+
+// Start verifies the image at repository in the background, tracked by an
+// operation of kind "verify". The status is passed to save once when the
+// verification starts and once when it ends.
+func Start(namespace, target, repository string, spec *Spec, owner *metav1.OwnerReference, save func(*Status) error) (*operations.Operation, *Status, error) {
+	op, err := operations.Start(namespace, "verify", target, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := &Status{
+		State:     StateVerifying,
+		Operation: op.ID,
+	}
+
+	if err := save(status); err != nil {
+		op.Fail(err)
+		return nil, nil, err
+	}
+
+	go func() {
+		op.SetStep("queued")
+		stop := heartbeat(op)
+		verifications <- struct{}{}
+		defer func() { <-verifications }()
+
+		op.SetStep("hashing")
+		ret := Verify(context.Background(), repository, spec)
+		ret.Operation = op.ID
+		stop()
+
+		if err := save(ret); err != nil {
+			klog.Errorf("verify %s: saving status: %s", target, err.Error())
+		}
+
+		switch ret.State {
+		case StateVerified:
+			op.Succeed(ret.Algorithm + " " + ret.Actual)
+		case StateMismatch:
+			op.Fail(fmt.Errorf("checksum mismatch: expected %s, got %s", ret.Expected, ret.Actual))
+		default:
+			op.Fail(fmt.Errorf("%s", ret.Message))
+		}
+	}()
+
+	return op, status, nil
+}
+
+// heartbeat keeps the operation's updatedAt current while the verification
+// waits for a slot or hashes, until the returned function is called.
+func heartbeat(op *operations.Operation) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				op.Touch()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Stalled reports whether the verification recorded in status is no longer
+// making progress: it never started, its operation is gone or over, or the
+// operation has not been updated for StaleAfter.
+func Stalled(namespace string, status *Status) (bool, error) {
+	if status == nil || (status.State != StatePending && status.State != StateVerifying) {
+		return false, nil
+	}
+
+	if status.Operation == "" {
+		return true, nil
+	}
+
+	op, err := operations.Load(namespace, status.Operation)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if op.State != operations.StateRunning {
+		return true, nil
+	}
+
+	if time.Since(op.UpdatedAt.Time) < StaleAfter {
+		return false, nil
+	}
+
+	op.Fail(fmt.Errorf("no progress since %s; the verification is started again", op.UpdatedAt.UTC().Format(time.RFC3339)))
+	return true, nil
+}
+
+// Verify streams the image through the hash named by spec and compares the
+// digest. Downloads that receive nothing for IdleTimeout are cancelled.
+func Verify(ctx context.Context, repository string, spec *Spec) *Status {
+	ret := &Status{}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	idle := time.AfterFunc(IdleTimeout, cancel)
+	defer idle.Stop()
+
+	stalled := func(err error) string {
+		if ctx.Err() != nil && parent.Err() == nil {
+			return fmt.Sprintf("no data received for %s", IdleTimeout)
+		}
+		return err.Error()
+	}
+
+	algorithm, expected, err := expectedDigest(ctx, repository, spec, idle)
+	if err != nil {
+		ret.State = StateFailed
+		ret.Message = stalled(err)
+		return ret
+	}
+
+	ret.Algorithm = algorithm
+	ret.Expected = expected
+
+	var h hash.Hash = sha256.New()
+	if algorithm == AlgorithmSHA512 {
+		h = sha512.New()
+	}
+
+	body, err := get(ctx, repository, idle)
+	if err != nil {
+		ret.State = StateFailed
+		ret.Message = stalled(err)
+		return ret
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(h, body); err != nil {
+		ret.State = StateFailed
+		ret.Message = "reading " + repository + ": " + stalled(err)
+		return ret
+	}
+
+	ret.Actual = hex.EncodeToString(h.Sum(nil))
+	ret.VerifiedAt = time.Now().UTC().Format(time.RFC3339)
+	ret.State = StateVerified
+	if ret.Actual != ret.Expected {
+		ret.State = StateMismatch
+	}
+
+	return ret
+}
+
+func expectedDigest(ctx context.Context, repository string, spec *Spec, idle *time.Timer) (string, string, error) {
+	switch {
+	case spec.SHA256 != "":
+		return AlgorithmSHA256, strings.ToLower(spec.SHA256), nil
+	case spec.SHA512 != "":
+		return AlgorithmSHA512, strings.ToLower(spec.SHA512), nil
+	}
+
+	u, err := url.Parse(repository)
+	if err != nil {
+		return "", "", err
+	}
+	filename := path.Base(u.Path)
+
+	body, err := get(ctx, spec.SHA256Sums, idle)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+
+	// Lines are "<digest> <name>" or "<digest> *<name>" for binary mode.
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		if strings.TrimPrefix(fields[1], "*") == filename {
+			return AlgorithmSHA256, strings.ToLower(fields[0]), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return "", "", fmt.Errorf("%s is not listed in %s", filename, spec.SHA256Sums)
+}
+
+// get fetches u and resets the idle timer whenever data arrives.
+func get(ctx context.Context, u string, idle *time.Timer) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	idle.Reset(IdleTimeout)
+	return &idleReader{ReadCloser: resp.Body, idle: idle}, nil
+}
+
+type idleReader struct {
+	io.ReadCloser
+	idle *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.idle.Reset(IdleTimeout)
+	}
+
+	return n, err
+}
+
+// Apply records status on meta unless it is the outcome of a verification that
+// has since been replaced by a newer one. It reports whether meta was changed.
+func Apply(meta *metav1.ObjectMeta, status *Status) (bool, error) {
+	spec, current := FromAnnotations(meta.Annotations)
+	if spec == nil {
+		return false, nil
+	}
+
+	if status.State != StateVerifying && (current == nil || current.Operation != status.Operation) {
+		return false, nil
+	}
+
+	return true, SetAnnotations(meta, spec, status)
+}
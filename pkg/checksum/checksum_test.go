@@ -0,0 +1,131 @@
+package checksum
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveFiles serves files from a temporary directory, standing in for an
+// image repository.
+func serveFiles(t *testing.T, files map[string]string) *httptest.Server {
+	dir, err := ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVerify(t *testing.T) {
+	image := strings.Repeat("kubeberth image\n", 4096)
+	sum256 := sha256.Sum256([]byte(image))
+	sum512 := sha512.Sum512([]byte(image))
+	digest256 := hex.EncodeToString(sum256[:])
+	digest512 := hex.EncodeToString(sum512[:])
+	other := strings.Repeat("0", 64)
+
+	server := serveFiles(t, map[string]string{
+		"focal.img":       image,
+		"SHA256SUMS":      other + "  jammy.img\n" + digest256 + "  focal.img\n",
+		"SHA256SUMS.bin":  other + " *jammy.img\n" + strings.ToUpper(digest256) + " *focal.img\n",
+		"SHA256SUMS.bad":  other + " *focal.img\n",
+		"SHA256SUMS.none": "# no images\n" + other + "  jammy.img\n",
+	})
+	repository := server.URL + "/focal.img"
+
+	tests := []struct {
+		name     string
+		spec     *Spec
+		state    string
+		expected string
+		message  string
+	}{
+		{name: "sha256", spec: &Spec{SHA256: digest256}, state: StateVerified, expected: digest256},
+		{name: "sha256 upper case", spec: &Spec{SHA256: strings.ToUpper(digest256)}, state: StateVerified, expected: digest256},
+		{name: "sha512", spec: &Spec{SHA512: digest512}, state: StateVerified, expected: digest512},
+		{name: "sha256 mismatch", spec: &Spec{SHA256: other}, state: StateMismatch, expected: other},
+		{name: "sha256sums", spec: &Spec{SHA256Sums: server.URL + "/SHA256SUMS"}, state: StateVerified, expected: digest256},
+		{name: "sha256sums binary mode", spec: &Spec{SHA256Sums: server.URL + "/SHA256SUMS.bin"}, state: StateVerified, expected: digest256},
+		{name: "sha256sums mismatch", spec: &Spec{SHA256Sums: server.URL + "/SHA256SUMS.bad"}, state: StateMismatch, expected: other},
+		{name: "sha256sums missing entry", spec: &Spec{SHA256Sums: server.URL + "/SHA256SUMS.none"}, state: StateFailed, message: "focal.img is not listed"},
+		{name: "sha256sums not found", spec: &Spec{SHA256Sums: server.URL + "/MISSING"}, state: StateFailed, message: "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ret := Verify(context.Background(), repository, tt.spec)
+			if ret.State != tt.state {
+				t.Fatalf("state = %s (%s), want %s", ret.State, ret.Message, tt.state)
+			}
+
+			if ret.Expected != tt.expected {
+				t.Errorf("expected = %s, want %s", ret.Expected, tt.expected)
+			}
+
+			if tt.state == StateVerified && ret.Actual != tt.expected {
+				t.Errorf("actual = %s, want %s", ret.Actual, tt.expected)
+			}
+
+			if !strings.Contains(ret.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", ret.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestVerifyMissingImage(t *testing.T) {
+	server := serveFiles(t, map[string]string{})
+
+	ret := Verify(context.Background(), server.URL+"/focal.img", &Spec{SHA256: strings.Repeat("0", 64)})
+	if ret.State != StateFailed || !strings.Contains(ret.Message, "404") {
+		t.Errorf("Verify of a missing image = %s (%s), want %s with 404", ret.State, ret.Message, StateFailed)
+	}
+}
+
+func TestVerifyStalledDownload(t *testing.T) {
+	defer func(timeout time.Duration) { IdleTimeout = timeout }(IdleTimeout)
+	IdleTimeout = 100 * time.Millisecond
+
+	// The mirror sends part of the image and then nothing until the client
+	// gives up.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("kubeberth image\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	done := make(chan *Status)
+	go func() {
+		done <- Verify(context.Background(), server.URL+"/focal.img", &Spec{SHA256: strings.Repeat("0", 64)})
+	}()
+
+	select {
+	case ret := <-done:
+		if ret.State != StateFailed || !strings.Contains(ret.Message, "no data received") {
+			t.Errorf("Verify of a stalled download = %s (%s), want %s with no data received", ret.State, ret.Message, StateFailed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Verify did not give up on a stalled download")
+	}
+}
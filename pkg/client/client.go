@@ -1,9 +1,16 @@
 package client
 
 import (
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
 	clientset "github.com/kubeberth/kubeberth-operator/pkg/clientset/versioned"
 )
 
 var (
-	Clientset *clientset.Clientset
+	Config     *rest.Config
+	Clientset  *clientset.Clientset
+	Kubernetes kubernetes.Interface
+	Dynamic    dynamic.Interface
 )
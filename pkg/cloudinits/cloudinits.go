@@ -6,8 +6,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
@@ -15,6 +20,13 @@ type CloudInit struct {
 	Name        string `json:"name"          binding:"required"`
 	UserData    string `json:"user_data"`
 	NetworkData string `json:"network_data"`
+	Secret      bool   `json:"secret"`
+	Redacted    bool   `json:"redacted,omitempty"`
+
+	// Parts are other cloud-inits composed, in order, before this one's own
+	// data when a server uses it.
+	Parts []string `json:"parts"`
+	Merge string   `json:"merge,omitempty"`
 }
 
 func convertCloudInit2CloudInit(cloudinit v1alpha1.CloudInit) *CloudInit {
@@ -22,6 +34,18 @@ func convertCloudInit2CloudInit(cloudinit v1alpha1.CloudInit) *CloudInit {
 		Name: cloudinit.ObjectMeta.Name,
 	}
 
+	ret.Parts, ret.Merge = composition(&cloudinit)
+	if len(ret.Parts) == 0 {
+		ret.Merge = ""
+	}
+
+	// The data of a secret cloud-init is only shown by convertVisibleCloudInit.
+	if IsSecret(&cloudinit) {
+		ret.Secret = true
+		ret.Redacted = true
+		return ret
+	}
+
 	if cloudinit.Spec.UserData != "" {
 		ret.UserData = cloudinit.Spec.UserData
 	}
@@ -33,23 +57,74 @@ func convertCloudInit2CloudInit(cloudinit v1alpha1.CloudInit) *CloudInit {
 	return ret
 }
 
+// convertVisibleCloudInit converts the cloud-init, filling in the data of a
+// secret one when the caller may see it.
+func convertVisibleCloudInit(ctx *gin.Context, namespace string, cloudinit v1alpha1.CloudInit) (*CloudInit, error) {
+	ret := convertCloudInit2CloudInit(cloudinit)
+	if !ret.Secret {
+		return ret, nil
+	}
+
+	ok, err := visible(ctx, namespace, &cloudinit)
+	if err != nil || !ok {
+		return ret, err
+	}
+
+	spec, err := Spec(namespace, &cloudinit)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.UserData = spec.UserData
+	ret.NetworkData = spec.NetworkData
+	ret.Redacted = false
+
+	return ret, nil
+}
+
+func validateCloudInit(c *CloudInit) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", c.Name)...)
+	errs = append(errs, ValidateSpec(v1alpha1.CloudInitSpec{
+		UserData:    c.UserData,
+		NetworkData: c.NetworkData,
+	})...)
+	errs = append(errs, validateComposition(c.Name, c.Parts, c.Merge)...)
+
+	return errs
+}
+
 func GetAllCloudInits(ctx *gin.Context) {
 	namespace := "kubeberth"
-	cloudinits, err := client.Clientset.CloudInits().CloudInits(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	cloudinits, err := client.Clientset.CloudInits().CloudInits(namespace).List(context.TODO(), opts)
 
-	if err != nil || len(cloudinits.Items) == 0 {
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
-	var ret []*CloudInit
+	ret := []*CloudInit{}
 	for _, cloudinit := range cloudinits.Items {
-		ret = append(ret, convertCloudInit2CloudInit(cloudinit))
+		c, err := convertVisibleCloudInit(ctx, namespace, cloudinit)
+		if err != nil {
+			revealError(ctx, err)
+			return
+		}
+		ret = append(ret, c)
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, cloudinits.ListMeta))
 }
 
 func GetCloudInit(ctx *gin.Context) {
@@ -64,7 +139,13 @@ func GetCloudInit(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertCloudInit2CloudInit(*cloudinit))
+	ret, err := convertVisibleCloudInit(ctx, namespace, *cloudinit)
+	if err != nil {
+		revealError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ret)
 }
 
 func CreateCloudInit(ctx *gin.Context) {
@@ -76,6 +157,14 @@ func CreateCloudInit(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateCloudInit(&c); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := c.Name
 	namespace := "kubeberth"
 	userData := c.UserData
@@ -92,6 +181,34 @@ func CreateCloudInit(ctx *gin.Context) {
 		},
 	}
 
+	if err := setComposition(cloudinit, c.Parts, c.Merge); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	errs, err := checkComposition(namespace, cloudinit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	spec := cloudinit.Spec
+	if c.Secret {
+		MarkSecret(cloudinit)
+	}
+
 	ret, err := client.Clientset.CloudInits().CloudInits(namespace).Create(context.TODO(), cloudinit, metav1.CreateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -100,6 +217,19 @@ func CreateCloudInit(ctx *gin.Context) {
 		return
 	}
 
+	if c.Secret {
+		if err := SaveSecret(namespace, ret, spec); err != nil {
+			if err := client.Clientset.CloudInits().CloudInits(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+				klog.Errorf("cloudinit %s: rolling back: %s", name, err.Error())
+			}
+
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	ctx.JSON(http.StatusOK, convertCloudInit2CloudInit(*ret))
 }
 
@@ -112,6 +242,14 @@ func UpdateCloudInit(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateCloudInit(&c); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := c.Name
 	namespace := "kubeberth"
 	userData := c.UserData
@@ -130,8 +268,59 @@ func UpdateCloudInit(ctx *gin.Context) {
 		NetworkData: networkData,
 	}
 
+	// A secret cloud-init reads back redacted, so putting it back unchanged
+	// keeps its data.
+	wasSecret := IsSecret(cloudinit)
+	previousSecret, stored := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecret]
+	if wasSecret && c.Secret && userData == "" && networkData == "" {
+		spec, err = Spec(namespace, cloudinit)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "update error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	cloudinit.Spec = spec
 
+	if err := setComposition(cloudinit, c.Parts, c.Merge); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	errs, err := checkComposition(namespace, cloudinit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	// The Secret is written first so that the data is never only in the
+	// previous spec.
+	if c.Secret {
+		MarkSecret(cloudinit)
+		if err := SaveSecret(namespace, cloudinit, spec); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "update error: " + err.Error(),
+			})
+			return
+		}
+	} else {
+		delete(cloudinit.ObjectMeta.Annotations, berth.AnnotationCloudInitSecret)
+	}
+
 	ret, err := client.Clientset.CloudInits().CloudInits(namespace).Update(context.TODO(), cloudinit, metav1.UpdateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -140,12 +329,46 @@ func UpdateCloudInit(ctx *gin.Context) {
 		return
 	}
 
+	if stored && !c.Secret {
+		if err := deleteSecret(namespace, previousSecret); err != nil {
+			klog.Errorf("cloudinit %s: removing secret: %s", name, err.Error())
+		}
+	}
+
 	ctx.JSON(http.StatusOK, convertCloudInit2CloudInit(*ret))
 }
 
 func DeleteCloudInit(ctx *gin.Context) {
 	name := ctx.Param("name")
 	namespace := "kubeberth"
+
+	if ctx.Query("force") != "true" {
+		refs, err := references.ServersReferencingCloudInit(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		composed, err := references.CloudInitsReferencingCloudInit(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+		refs = append(refs, composed...)
+
+		if len(refs) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":       "cloudinit " + name + " is still referenced",
+				"referenced_by": refs,
+			})
+			return
+		}
+	}
+
 	err := client.Clientset.CloudInits().CloudInits(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 
 	if err != nil {
@@ -0,0 +1,328 @@
+package cloudinits
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const (
+	// MergeMultipart puts every part into a MIME multipart user data.
+	MergeMultipart = "multipart"
+	// MergeCloudConfig merges cloud-configs into one: mappings are merged,
+	// lists appended and later scalars win.
+	MergeCloudConfig = "cloud-config"
+)
+
+// maxPartsDepth bounds how deeply composed cloud-inits may nest.
+const maxPartsDepth = 5
+
+// Resolved is the final data of a cloud-init along with every cloud-init it
+// was composed from.
+type Resolved struct {
+	Spec    v1alpha1.CloudInitSpec
+	Sources []*v1alpha1.CloudInit
+}
+
+// Secret reports whether any of the data came from a secret cloud-init.
+func (r *Resolved) Secret() bool {
+	return len(r.SecretNames()) > 0
+}
+
+// SecretNames returns the Secrets the data was read from, in order.
+func (r *Resolved) SecretNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, source := range r.Sources {
+		for _, name := range secretNames(source) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// IsComposed reports whether the cloud-init is built from parts and so can
+// only be used through a server's rendered cloud-init.
+func IsComposed(cloudinit *v1alpha1.CloudInit) bool {
+	_, ok := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitParts]
+	return ok
+}
+
+// composition returns the parts and merge mode of a cloud-init.
+func composition(cloudinit *v1alpha1.CloudInit) ([]string, string) {
+	parts := []string{}
+	if data, ok := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitParts]; ok {
+		if err := json.Unmarshal([]byte(data), &parts); err != nil {
+			parts = []string{}
+		}
+	}
+
+	merge := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitMerge]
+	if merge == "" {
+		merge = MergeMultipart
+	}
+
+	return parts, merge
+}
+
+// setComposition records the parts and merge mode on the cloud-init.
+func setComposition(cloudinit *v1alpha1.CloudInit, parts []string, merge string) error {
+	if len(parts) == 0 {
+		delete(cloudinit.ObjectMeta.Annotations, berth.AnnotationCloudInitParts)
+		delete(cloudinit.ObjectMeta.Annotations, berth.AnnotationCloudInitMerge)
+		return nil
+	}
+
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return err
+	}
+
+	if cloudinit.ObjectMeta.Annotations == nil {
+		cloudinit.ObjectMeta.Annotations = map[string]string{}
+	}
+	cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitParts] = string(data)
+
+	if merge == "" {
+		merge = MergeMultipart
+	}
+	cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitMerge] = merge
+
+	return nil
+}
+
+func validateComposition(name string, parts []string, merge string) validation.ErrorList {
+	var errs validation.ErrorList
+	seen := map[string]bool{}
+	for i, part := range parts {
+		field := validation.Index("parts", i)
+		errs = append(errs, validation.ValidateName(field, part)...)
+		if part == name {
+			errs = append(errs, validation.NewFieldError(field, "must not refer to the cloudinit itself"))
+		}
+		if seen[part] {
+			errs = append(errs, validation.NewFieldError(field, "cloudinit "+part+" is given more than once"))
+		}
+		seen[part] = true
+	}
+
+	switch merge {
+	case "", MergeMultipart, MergeCloudConfig:
+	default:
+		errs = append(errs, validation.NewFieldError("merge", "must be multipart or cloud-config"))
+	}
+
+	return errs
+}
+
+// checkComposition resolves the parts of a cloud-init about to be saved, so
+// that missing parts and cycles are reported up front.
+func checkComposition(namespace string, cloudinit *v1alpha1.CloudInit) (validation.ErrorList, error) {
+	if !IsComposed(cloudinit) {
+		return nil, nil
+	}
+
+	// Its own data is the one given rather than what is stored.
+	c := *cloudinit
+	c.ObjectMeta.Annotations = map[string]string{}
+	for k, v := range cloudinit.ObjectMeta.Annotations {
+		c.ObjectMeta.Annotations[k] = v
+	}
+	delete(c.ObjectMeta.Annotations, berth.AnnotationCloudInitSecret)
+
+	_, errs, err := Resolve(namespace, &c)
+	return errs, err
+}
+
+// Resolve returns the data of a cloud-init with its parts composed in order,
+// followed by its own data. The last network_data given wins.
+func Resolve(namespace string, cloudinit *v1alpha1.CloudInit) (*Resolved, validation.ErrorList, error) {
+	return resolve(namespace, cloudinit, map[string]bool{}, 0)
+}
+
+func resolve(namespace string, cloudinit *v1alpha1.CloudInit, visiting map[string]bool, depth int) (*Resolved, validation.ErrorList, error) {
+	var errs validation.ErrorList
+	spec, err := Spec(namespace, cloudinit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret := &Resolved{
+		Spec:    spec,
+		Sources: []*v1alpha1.CloudInit{cloudinit},
+	}
+
+	if !IsComposed(cloudinit) {
+		return ret, errs, nil
+	}
+
+	name := cloudinit.ObjectMeta.Name
+	if depth >= maxPartsDepth {
+		return nil, append(errs, validation.NewFieldError("parts", fmt.Sprintf("cloudinit %s nests parts more than %d deep", name, maxPartsDepth))), nil
+	}
+
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	names, merge := composition(cloudinit)
+	userData := []string{}
+	networkData := ""
+	for i, part := range names {
+		field := validation.Index("parts", i)
+		if visiting[part] {
+			errs = append(errs, validation.NewFieldError(field, "cloudinit "+part+" includes "+name+" again"))
+			continue
+		}
+
+		c, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), part, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			errs = append(errs, validation.NewFieldError(field, "cloudinit "+part+" does not exist"))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r, e, err := resolve(namespace, c, visiting, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(e) > 0 {
+			for _, fe := range e {
+				fe.Field = field + "." + fe.Field
+			}
+			errs = append(errs, e...)
+			continue
+		}
+
+		ret.Sources = append(ret.Sources, r.Sources...)
+		if r.Spec.UserData != "" {
+			userData = append(userData, r.Spec.UserData)
+		}
+		if r.Spec.NetworkData != "" {
+			networkData = r.Spec.NetworkData
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	if spec.UserData != "" {
+		userData = append(userData, spec.UserData)
+	}
+	if spec.NetworkData != "" {
+		networkData = spec.NetworkData
+	}
+
+	var composed string
+	if merge == MergeCloudConfig {
+		composed, err = mergeCloudConfigs(userData)
+	} else {
+		composed, err = composeMultipart(userData)
+	}
+	if err != nil {
+		return nil, append(errs, validation.NewFieldError("user_data", "composing parts: "+err.Error())), nil
+	}
+
+	ret.Spec = v1alpha1.CloudInitSpec{
+		UserData:    composed,
+		NetworkData: networkData,
+	}
+
+	return ret, errs, nil
+}
+
+// composeMultipart flattens user data, multipart or not, into one multipart.
+func composeMultipart(userData []string) (string, error) {
+	parts := []Part{}
+	for _, data := range userData {
+		p, err := Parts(data)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, p...)
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	return ComposeMultipart(parts)
+}
+
+// mergeCloudConfigs merges cloud-configs in order into one.
+func mergeCloudConfigs(userData []string) (string, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i, data := range userData {
+		if !isCloudConfig(data) {
+			return "", fmt.Errorf("part %d is not a cloud-config", i)
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			return "", fmt.Errorf("part %d: %s", i, err.Error())
+		}
+
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+			continue
+		}
+
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return "", fmt.Errorf("part %d is not a mapping", i)
+		}
+
+		mergeMappings(merged, doc.Content[0])
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(merged); err != nil {
+		return "", err
+	}
+
+	return "#cloud-config\n" + buf.String(), nil
+}
+
+func mergeMappings(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		current := mappingValue(dst, key.Value)
+		switch {
+		case current == nil:
+			// A part's header reads as a comment on its first key; it is
+			// written once for the merged document.
+			if isCloudConfig(key.HeadComment) {
+				lines := strings.SplitN(key.HeadComment, "\n", 2)
+				key.HeadComment = ""
+				if len(lines) == 2 {
+					key.HeadComment = lines[1]
+				}
+			}
+			dst.Content = append(dst.Content, key, value)
+		case current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeMappings(current, value)
+		case current.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			current.Content = append(current.Content, value.Content...)
+		default:
+			*current = *value
+		}
+	}
+}
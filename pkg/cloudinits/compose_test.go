@@ -0,0 +1,161 @@
+package cloudinits
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeCloudConfigs(t *testing.T) {
+	tests := []struct {
+		name     string
+		userData []string
+		want     string
+		err      string
+	}{
+		{
+			name: "later scalars win, lists are appended and mappings merged",
+			userData: []string{
+				"#cloud-config\n# base packages\npackages:\n  - curl\ntimezone: UTC\nchpasswd:\n  expire: false\n",
+				"#cloud-config\npackages:\n  - jq\ntimezone: Asia/Tokyo\nchpasswd:\n  list: |\n    ubuntu:ubuntu\n",
+			},
+			want: "#cloud-config\n# base packages\npackages:\n  - curl\n  - jq\ntimezone: Asia/Tokyo\nchpasswd:\n  expire: false\n  list: |\n    ubuntu:ubuntu\n",
+		},
+		{
+			name: "a list replaces a scalar",
+			userData: []string{
+				"#cloud-config\nruncmd: echo\n",
+				"#cloud-config\nruncmd:\n  - [ls, /]\n",
+			},
+			want: "#cloud-config\nruncmd:\n  - [ls, /]\n",
+		},
+		{
+			name: "a mapping replaces a list",
+			userData: []string{
+				"#cloud-config\napt:\n  - a\n",
+				"#cloud-config\napt:\n  preserve_sources_list: true\n",
+			},
+			want: "#cloud-config\napt:\n  preserve_sources_list: true\n",
+		},
+		{
+			name: "an empty cloud-config adds nothing",
+			userData: []string{
+				"#cloud-config\n",
+				"#cloud-config\nhostname: test\n",
+			},
+			want: "#cloud-config\nhostname: test\n",
+		},
+		{
+			name:     "the header must be the whole first line",
+			userData: []string{"#cloud-config\nhostname: test\n", "#cloud-config-archive\n- content: x\n"},
+			err:      "part 1 is not a cloud-config",
+		},
+		{
+			name:     "scripts cannot be merged",
+			userData: []string{"#!/bin/sh\necho hello\n"},
+			err:      "part 0 is not a cloud-config",
+		},
+		{
+			name:     "multipart cannot be merged",
+			userData: []string{"Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n--b--\n"},
+			err:      "part 0 is not a cloud-config",
+		},
+		{
+			name:     "a cloud-config must be a mapping",
+			userData: []string{"#cloud-config\n- a\n"},
+			err:      "part 0 is not a mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeCloudConfigs(tt.userData)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected err %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("merged =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeMultipart(t *testing.T) {
+	script := "#!/bin/sh\necho hello\n"
+	config := "#cloud-config\nhostname: test\n"
+	archive := "#cloud-config-archive\n- content: x\n"
+	nested, err := ComposeMultipart([]Part{
+		{ContentType: "text/cloud-config", Content: config},
+		{ContentType: "text/x-shellscript", Content: script},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		userData []string
+		want     []Part
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:     "a single part is kept as is",
+			userData: []string{config},
+			want:     []Part{{ContentType: "text/cloud-config", Content: config}},
+		},
+		{
+			name:     "parts keep their order",
+			userData: []string{script, config, archive},
+			want: []Part{
+				{ContentType: "text/x-shellscript", Content: script},
+				{ContentType: "text/cloud-config", Content: config},
+				{ContentType: "text/cloud-config-archive", Content: archive},
+			},
+		},
+		{
+			name:     "multipart is flattened in place",
+			userData: []string{archive, nested, script},
+			want: []Part{
+				{ContentType: "text/cloud-config-archive", Content: archive},
+				{ContentType: "text/cloud-config", Content: config},
+				{ContentType: "text/x-shellscript", Content: script},
+				{ContentType: "text/x-shellscript", Content: script},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			composed, err := composeMultipart(tt.userData)
+			if err != nil {
+				t.Fatalf("unexpected err %v", err)
+			}
+
+			if len(tt.want) == 1 {
+				if composed != tt.want[0].Content {
+					t.Errorf("composed = %q, want %q", composed, tt.want[0].Content)
+				}
+				return
+			}
+
+			got, err := Parts(composed)
+			if err != nil {
+				t.Fatalf("Parts: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
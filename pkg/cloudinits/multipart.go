@@ -0,0 +1,223 @@
+package cloudinits
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Part is one document of a multipart user data.
+type Part struct {
+	ContentType string
+	Content     string
+}
+
+// contentType tells the MIME type of a user data from its header.
+func contentType(data string) string {
+	for _, header := range userDataHeaders {
+		if !strings.HasPrefix(data, header) {
+			continue
+		}
+		if header == "#cloud-config" && !isCloudConfig(data) {
+			break
+		}
+		for t, h := range partContentTypes {
+			if h == header {
+				return t
+			}
+		}
+	}
+
+	return "text/plain"
+}
+
+// Parts splits a user data into its documents. A user data that is not MIME
+// multipart is a single part.
+func Parts(data string) ([]Part, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	if !isMultipart(data) {
+		return []Part{{ContentType: contentType(data), Content: data}}, nil
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []Part{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, Part{ContentType: t, Content: string(content)})
+	}
+
+	return parts, nil
+}
+
+// ComposeMultipart builds a MIME multipart user data from parts, in order. A
+// single part is returned as is.
+func ComposeMultipart(parts []Part) (string, error) {
+	if len(parts) == 1 {
+		return parts[0].Content, nil
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.ContentType+`; charset="utf-8"`)
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="part-%03d"`, i+1))
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(pw, part.Content); err != nil {
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\r\nMIME-Version: 1.0\r\n\r\n", w.Boundary()) + buf.String(), nil
+}
+
+// AddAuthorizedKeys adds keys to the ssh_authorized_keys of the default user.
+// They are merged into the first cloud-config of the user data; a user data
+// without one gets a cloud-config part of its own.
+func AddAuthorizedKeys(userData string, keys []string) (string, error) {
+	if len(keys) == 0 {
+		return userData, nil
+	}
+
+	parts, err := Parts(userData)
+	if err != nil {
+		return "", err
+	}
+
+	merged := false
+	for i, part := range parts {
+		if part.ContentType != "text/cloud-config" {
+			continue
+		}
+
+		parts[i].Content, err = mergeAuthorizedKeys(part.Content, keys)
+		if err != nil {
+			return "", err
+		}
+		merged = true
+		break
+	}
+
+	if !merged {
+		content, err := mergeAuthorizedKeys("", keys)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, Part{ContentType: "text/cloud-config", Content: content})
+	}
+
+	if !isMultipart(userData) && len(parts) == 1 {
+		return parts[0].Content, nil
+	}
+
+	return ComposeMultipart(parts)
+}
+
+func mergeAuthorizedKeys(cloudConfig string, keys []string) (string, error) {
+	// The header is a comment to YAML; it is written back below.
+	body := cloudConfig
+	if isCloudConfig(body) {
+		body = strings.TrimPrefix(body, strings.SplitN(body, "\n", 2)[0])
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+		return "", err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("cloud-config must be a mapping")
+	}
+
+	authorized := mappingValue(root, "ssh_authorized_keys")
+	if authorized == nil {
+		authorized = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "ssh_authorized_keys"}, authorized)
+	}
+
+	if authorized.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("ssh_authorized_keys must be a list")
+	}
+
+	seen := map[string]bool{}
+	for _, key := range authorized.Content {
+		seen[key.Value] = true
+	}
+
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		authorized.Content = append(authorized.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key})
+		seen[key] = true
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return "", err
+	}
+
+	return "#cloud-config\n" + buf.String(), nil
+}
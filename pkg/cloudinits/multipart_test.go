@@ -0,0 +1,184 @@
+package cloudinits
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"#cloud-config\nhostname: test\n", "text/cloud-config"},
+		{"#cloud-config \r\nhostname: test\n", "text/cloud-config"},
+		{"#cloud-config-archive\n- content: x\n", "text/cloud-config-archive"},
+		{"#cloud-configuration\n", "text/plain"},
+		{"#!/bin/sh\necho hello\n", "text/x-shellscript"},
+		{"#cloud-boothook\necho hello\n", "text/cloud-boothook"},
+		{"#include\nhttps://example.com/user-data\n", "text/x-include-url"},
+		{"## template: jinja\n#cloud-config\n", "text/jinja2"},
+		{"hostname: test\n", "text/plain"},
+	}
+
+	for _, tt := range tests {
+		if got := contentType(tt.data); got != tt.want {
+			t.Errorf("contentType(%q) = %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParts(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Part
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "not multipart",
+			data: "#!/bin/sh\necho hello\n",
+			want: []Part{{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho hello\n"}},
+		},
+		{
+			name: "base64 part",
+			data: "Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n" +
+				"--b\nContent-Type: text/cloud-config\nContent-Transfer-Encoding: base64\n\nI2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogdGVzdAo=\n" +
+				"--b\nContent-Type: text/x-shellscript; charset=\"utf-8\"\n\n#!/bin/sh\n" +
+				"--b--\n",
+			want: []Part{
+				{ContentType: "text/cloud-config", Content: "#cloud-config\nhostname: test\n"},
+				{ContentType: "text/x-shellscript", Content: "#!/bin/sh"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := Parts(tt.data)
+		if err != nil {
+			t.Errorf("Parts(%s): unexpected err %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parts(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Parts("Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n--b\nContent-Type: ;;\n\nx\n--b--\n"); err == nil {
+		t.Errorf("Parts accepted a part with an invalid Content-Type")
+	}
+}
+
+func TestComposeMultipartRoundTrip(t *testing.T) {
+	parts := []Part{
+		{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho first\n"},
+		{ContentType: "text/cloud-config", Content: "#cloud-config\nhostname: test\n"},
+		{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho last\n"},
+	}
+
+	composed, err := ComposeMultipart(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := ValidateUserData("user_data", composed); len(errs) > 0 {
+		t.Errorf("composed multipart is invalid: %v", errs)
+	}
+
+	got, err := Parts(composed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, parts) {
+		t.Errorf("round trip = %v, want %v", got, parts)
+	}
+
+	single, err := ComposeMultipart(parts[1:2])
+	if err != nil || single != parts[1].Content {
+		t.Errorf("ComposeMultipart of one part = %q, %v, want it as is", single, err)
+	}
+}
+
+func TestAddAuthorizedKeys(t *testing.T) {
+	const (
+		key1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOne one"
+		key2 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAITwo two"
+	)
+
+	tests := []struct {
+		name     string
+		userData string
+		keys     []string
+		want     string
+	}{
+		{
+			name:     "no keys",
+			userData: "#cloud-config\nhostname: test\n",
+			want:     "#cloud-config\nhostname: test\n",
+		},
+		{
+			name: "empty user data",
+			keys: []string{key1},
+			want: "#cloud-config\nssh_authorized_keys:\n  - " + key1 + "\n",
+		},
+		{
+			name:     "merged into existing keys without duplicates",
+			userData: "#cloud-config\nhostname: test\nssh_authorized_keys:\n  - " + key1 + "\n",
+			keys:     []string{key1, key2, key2},
+			want:     "#cloud-config\nhostname: test\nssh_authorized_keys:\n  - " + key1 + "\n  - " + key2 + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddAuthorizedKeys(tt.userData, tt.keys)
+			if err != nil {
+				t.Fatalf("unexpected err %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("user data =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddAuthorizedKeysMultipart(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOne one"
+	script := Part{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho hello\n"}
+
+	// A user data without a cloud-config gets one after its parts.
+	got, err := AddAuthorizedKeys(script.Content, []string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := Parts(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Part{script, {ContentType: "text/cloud-config", Content: "#cloud-config\nssh_authorized_keys:\n  - " + key + "\n"}}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %v, want %v", parts, want)
+	}
+
+	// Adding the same key again leaves the cloud-config part alone.
+	again, err := AddAuthorizedKeys(got, []string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err = Parts(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts after adding the key again = %v, want %v", parts, want)
+	}
+}
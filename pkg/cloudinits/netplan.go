@@ -0,0 +1,298 @@
+package cloudinits
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+// v1ConfigTypes are the entry types of a version 1 network config.
+var v1ConfigTypes = map[string]bool{
+	"physical":   true,
+	"bond":       true,
+	"bridge":     true,
+	"vlan":       true,
+	"nameserver": true,
+	"route":      true,
+}
+
+// v2DeviceTypes are the device sections of a version 2 (netplan) network
+// config.
+var v2DeviceTypes = map[string]bool{
+	"ethernets": true,
+	"bonds":     true,
+	"bridges":   true,
+	"vlans":     true,
+	"wifis":     true,
+}
+
+// ValidateNetworkData checks network_data against the version 1 or version 2
+// network config format, optionally wrapped in a top-level network key.
+func ValidateNetworkData(field, data string) validation.ErrorList {
+	doc, errs := parseYAML(field, data)
+	if doc == nil {
+		return errs
+	}
+
+	root := doc.Content[0]
+	if network := mappingValue(root, "network"); network != nil {
+		root = network
+		field = validation.Child(field, "network")
+	}
+
+	if errs := expectKind(field, root, yaml.MappingNode); len(errs) > 0 {
+		return errs
+	}
+
+	// cloud-init's way of turning network configuration off altogether.
+	if config := mappingValue(root, "config"); config != nil && config.Value == "disabled" {
+		return errs
+	}
+
+	version := mappingValue(root, "version")
+	if version == nil {
+		return append(errs, nodeError(validation.Child(field, "version"), root, "must be specified"))
+	}
+
+	switch version.Value {
+	case "1":
+		errs = append(errs, validateNetworkV1(field, root)...)
+	case "2":
+		errs = append(errs, validateNetworkV2(field, root)...)
+	default:
+		errs = append(errs, nodeError(validation.Child(field, "version"), version, "must be 1 or 2"))
+	}
+
+	return errs
+}
+
+func validateNetworkV1(field string, root *yaml.Node) validation.ErrorList {
+	var errs validation.ErrorList
+	config := mappingValue(root, "config")
+	if config == nil {
+		return append(errs, nodeError(validation.Child(field, "config"), root, "must be specified"))
+	}
+
+	field = validation.Child(field, "config")
+	if errs := expectKind(field, config, yaml.SequenceNode); len(errs) > 0 {
+		return errs
+	}
+
+	for i, entry := range config.Content {
+		f := validation.Index(field, i)
+		if e := expectKind(f, entry, yaml.MappingNode); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+
+		t := mappingValue(entry, "type")
+		if t == nil {
+			errs = append(errs, nodeError(validation.Child(f, "type"), entry, "must be specified"))
+			continue
+		}
+
+		if !v1ConfigTypes[t.Value] {
+			errs = append(errs, nodeError(validation.Child(f, "type"), t, "unknown type "+strconv.Quote(t.Value)))
+			continue
+		}
+
+		switch t.Value {
+		case "physical", "bond", "bridge", "vlan":
+			if mappingValue(entry, "name") == nil {
+				errs = append(errs, nodeError(validation.Child(f, "name"), entry, "must be specified"))
+			}
+		}
+
+		if t.Value == "vlan" {
+			for _, key := range []string{"vlan_link", "vlan_id"} {
+				if mappingValue(entry, key) == nil {
+					errs = append(errs, nodeError(validation.Child(f, key), entry, "must be specified"))
+				}
+			}
+		}
+
+		if subnets := mappingValue(entry, "subnets"); subnets != nil {
+			errs = append(errs, validateSubnetsV1(validation.Child(f, "subnets"), subnets)...)
+		}
+	}
+
+	return errs
+}
+
+func validateSubnetsV1(field string, subnets *yaml.Node) validation.ErrorList {
+	if errs := expectKind(field, subnets, yaml.SequenceNode); len(errs) > 0 {
+		return errs
+	}
+
+	var errs validation.ErrorList
+	for i, subnet := range subnets.Content {
+		f := validation.Index(field, i)
+		if e := expectKind(f, subnet, yaml.MappingNode); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+
+		t := mappingValue(subnet, "type")
+		if t == nil {
+			errs = append(errs, nodeError(validation.Child(f, "type"), subnet, "must be specified"))
+			continue
+		}
+
+		if t.Value == "static" || t.Value == "static6" {
+			address := mappingValue(subnet, "address")
+			if address == nil {
+				errs = append(errs, nodeError(validation.Child(f, "address"), subnet, "must be specified"))
+			} else {
+				errs = append(errs, validateAddress(validation.Child(f, "address"), address)...)
+			}
+		}
+
+		if gateway := mappingValue(subnet, "gateway"); gateway != nil {
+			errs = append(errs, validateIP(validation.Child(f, "gateway"), gateway)...)
+		}
+	}
+
+	return errs
+}
+
+func validateNetworkV2(field string, root *yaml.Node) validation.ErrorList {
+	var errs validation.ErrorList
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		f := validation.Child(field, key.Value)
+		switch {
+		case key.Value == "version":
+		case key.Value == "renderer":
+			if value.Value != "networkd" && value.Value != "NetworkManager" {
+				errs = append(errs, nodeError(f, value, "must be networkd or NetworkManager"))
+			}
+		case v2DeviceTypes[key.Value]:
+			errs = append(errs, validateDevicesV2(f, key.Value, value)...)
+		default:
+			errs = append(errs, nodeError(f, key, "unknown key"))
+		}
+	}
+
+	return errs
+}
+
+func validateDevicesV2(field, kind string, devices *yaml.Node) validation.ErrorList {
+	if errs := expectKind(field, devices, yaml.MappingNode); len(errs) > 0 {
+		return errs
+	}
+
+	var errs validation.ErrorList
+	for i := 0; i+1 < len(devices.Content); i += 2 {
+		id, device := devices.Content[i], devices.Content[i+1]
+		f := validation.Child(field, id.Value)
+		if e := expectKind(f, device, yaml.MappingNode); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+
+		for _, key := range []string{"dhcp4", "dhcp6"} {
+			if v := mappingValue(device, key); v != nil && !isBool(v) {
+				errs = append(errs, nodeError(validation.Child(f, key), v, "must be true or false"))
+			}
+		}
+
+		if addresses := mappingValue(device, "addresses"); addresses != nil {
+			errs = append(errs, validateAddresses(validation.Child(f, "addresses"), addresses)...)
+		}
+
+		for _, key := range []string{"gateway4", "gateway6"} {
+			if v := mappingValue(device, key); v != nil {
+				errs = append(errs, validateIP(validation.Child(f, key), v)...)
+			}
+		}
+
+		if nameservers := mappingValue(device, "nameservers"); nameservers != nil {
+			nf := validation.Child(f, "nameservers")
+			if e := expectKind(nf, nameservers, yaml.MappingNode); len(e) > 0 {
+				errs = append(errs, e...)
+			} else if addresses := mappingValue(nameservers, "addresses"); addresses != nil {
+				af := validation.Child(nf, "addresses")
+				if e := expectKind(af, addresses, yaml.SequenceNode); len(e) > 0 {
+					errs = append(errs, e...)
+				} else {
+					for j, address := range addresses.Content {
+						errs = append(errs, validateIP(validation.Index(af, j), address)...)
+					}
+				}
+			}
+		}
+
+		switch kind {
+		case "vlans":
+			for _, key := range []string{"id", "link"} {
+				if mappingValue(device, key) == nil {
+					errs = append(errs, nodeError(validation.Child(f, key), device, "must be specified"))
+				}
+			}
+		case "bonds", "bridges":
+			if interfaces := mappingValue(device, "interfaces"); interfaces != nil {
+				errs = append(errs, expectKind(validation.Child(f, "interfaces"), interfaces, yaml.SequenceNode)...)
+			}
+		case "wifis":
+			if mappingValue(device, "access-points") == nil {
+				errs = append(errs, nodeError(validation.Child(f, "access-points"), device, "must be specified"))
+			}
+		}
+	}
+
+	return errs
+}
+
+// isBool also accepts the YAML 1.1 spellings netplan reads as booleans.
+func isBool(node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode {
+		return false
+	}
+
+	switch strings.ToLower(node.Value) {
+	case "true", "false", "yes", "no", "on", "off":
+		return true
+	}
+
+	return false
+}
+
+func validateAddresses(field string, addresses *yaml.Node) validation.ErrorList {
+	if errs := expectKind(field, addresses, yaml.SequenceNode); len(errs) > 0 {
+		return errs
+	}
+
+	var errs validation.ErrorList
+	for i, address := range addresses.Content {
+		errs = append(errs, validateAddress(validation.Index(field, i), address)...)
+	}
+
+	return errs
+}
+
+// validateAddress accepts an address in CIDR notation.
+func validateAddress(field string, node *yaml.Node) validation.ErrorList {
+	var errs validation.ErrorList
+	if node.Kind != yaml.ScalarNode {
+		return append(errs, nodeError(field, node, "must be an address like 192.0.2.10/24"))
+	}
+
+	if _, _, err := net.ParseCIDR(node.Value); err != nil {
+		errs = append(errs, nodeError(field, node, "must be an address like 192.0.2.10/24"))
+	}
+
+	return errs
+}
+
+func validateIP(field string, node *yaml.Node) validation.ErrorList {
+	var errs validation.ErrorList
+	if node.Kind != yaml.ScalarNode || net.ParseIP(node.Value) == nil {
+		errs = append(errs, nodeError(field, node, "must be a valid IP address"))
+	}
+
+	return errs
+}
@@ -0,0 +1,108 @@
+package cloudinits
+
+import (
+	"testing"
+)
+
+func TestValidateNetworkData(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		field string
+		line  int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "version 2",
+			data: "version: 2\nethernets:\n  enp1s0:\n    dhcp4: yes\n    addresses: [192.0.2.10/24]\n    gateway4: 192.0.2.1\n    nameservers:\n      addresses: [192.0.2.53, 2001:db8::53]\n",
+		},
+		{
+			name: "version 2 under network",
+			data: "network:\n  version: 2\n  renderer: networkd\n  vlans:\n    vlan10:\n      id: 10\n      link: enp1s0\n",
+		},
+		{
+			name: "version 1",
+			data: "version: 1\nconfig:\n  - type: physical\n    name: eth0\n    subnets:\n      - type: static\n        address: 192.0.2.10/24\n        gateway: 192.0.2.1\n  - type: nameserver\n    address: [192.0.2.53]\n",
+		},
+		{
+			name: "disabled",
+			data: "network:\n  config: disabled\n",
+		},
+		{
+			name:  "missing version",
+			data:  "ethernets: {}\n",
+			field: "network_data.version",
+			line:  1,
+		},
+		{
+			name:  "unknown version",
+			data:  "version: 3\n",
+			field: "network_data.version",
+			line:  1,
+		},
+		{
+			name:  "version 2 unknown key",
+			data:  "version: 2\nethernet:\n  enp1s0: {}\n",
+			field: "network_data.ethernet",
+			line:  2,
+		},
+		{
+			name:  "version 2 address without prefix",
+			data:  "version: 2\nethernets:\n  enp1s0:\n    addresses:\n      - 192.0.2.10\n",
+			field: "network_data.ethernets.enp1s0.addresses[0]",
+			line:  5,
+		},
+		{
+			name:  "version 2 dhcp4 not a boolean",
+			data:  "network:\n  version: 2\n  ethernets:\n    enp1s0:\n      dhcp4: sometimes\n",
+			field: "network_data.network.ethernets.enp1s0.dhcp4",
+			line:  5,
+		},
+		{
+			name:  "version 2 vlan without link",
+			data:  "version: 2\nvlans:\n  vlan10:\n    id: 10\n",
+			field: "network_data.vlans.vlan10.link",
+			line:  4,
+		},
+		{
+			name:  "version 1 unknown type",
+			data:  "version: 1\nconfig:\n  - type: tunnel\n",
+			field: "network_data.config[0].type",
+			line:  3,
+		},
+		{
+			name:  "version 1 static subnet without address",
+			data:  "version: 1\nconfig:\n  - type: physical\n    name: eth0\n    subnets:\n      - type: static\n",
+			field: "network_data.config[0].subnets[0].address",
+			line:  6,
+		},
+		{
+			name:  "not yaml",
+			data:  "version: 2\nethernets: [\n",
+			field: "network_data",
+			line:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateNetworkData("network_data", tt.data)
+			if tt.field == "" {
+				if len(errs) > 0 {
+					t.Errorf("unexpected errs %v", errs)
+				}
+				return
+			}
+
+			if len(errs) != 1 {
+				t.Fatalf("errs = %v, want one error on %s", errs, tt.field)
+			}
+
+			if errs[0].Field != tt.field || errs[0].Line != tt.line {
+				t.Errorf("error on %s line %d (%s), want %s line %d", errs[0].Field, errs[0].Line, errs[0].Message, tt.field, tt.line)
+			}
+		})
+	}
+}
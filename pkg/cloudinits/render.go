@@ -0,0 +1,144 @@
+package cloudinits
+
+import (
+	"context"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestRender struct {
+	Name       string            `json:"name"`
+	Hostname   string            `json:"hostname"`
+	MACAddress string            `json:"mac_address"`
+	IP         string            `json:"ip"`
+	Variables  map[string]string `json:"variables"`
+}
+
+// RenderCloudInit previews a cloud-init as it would be rendered for a server,
+// parts included. Output that draws on a secret cloud-init is only validated
+// unless revealed.
+func RenderCloudInit(ctx *gin.Context) {
+	var r RequestRender
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&r); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "request invalid: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	cloudinit, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	resolved, errs, err := Resolve(namespace, cloudinit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	spec, errs := Render(resolved.Spec, &TemplateData{
+		Name:       r.Name,
+		Hostname:   r.Hostname,
+		MACAddress: r.MACAddress,
+		IP:         r.IP,
+		Vars:       r.Variables,
+	})
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	respondResolved(ctx, namespace, name, resolved, spec)
+}
+
+// PreviewCloudInit shows the document a composed cloud-init resolves to before
+// it is rendered for a server.
+func PreviewCloudInit(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	cloudinit, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	resolved, errs, err := Resolve(namespace, cloudinit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	respondResolved(ctx, namespace, name, resolved, resolved.Spec)
+}
+
+// respondResolved shows spec unless some of it came from a secret cloud-init
+// the caller may not see.
+func respondResolved(ctx *gin.Context, namespace, name string, resolved *Resolved, spec v1alpha1.CloudInitSpec) {
+	for _, source := range resolved.Sources {
+		ok, err := visible(ctx, namespace, source)
+		if err != nil {
+			revealError(ctx, err)
+			return
+		}
+
+		if !ok {
+			ctx.JSON(http.StatusOK, &CloudInit{
+				Name:     name,
+				Secret:   true,
+				Redacted: true,
+				Parts:    []string{},
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, &CloudInit{
+		Name:        name,
+		UserData:    spec.UserData,
+		NetworkData: spec.NetworkData,
+		Secret:      resolved.Secret(),
+		Parts:       []string{},
+	})
+}
@@ -0,0 +1,195 @@
+package cloudinits
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/authz"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+// The keys KubeVirt expects in a cloud-init NoCloud secret.
+const (
+	secretKeyUserData    = "userdata"
+	secretKeyNetworkData = "networkdata"
+)
+
+func secretName(cloudinit string) string {
+	return "cloudinit-" + cloudinit
+}
+
+// IsSecret reports whether the cloud-init's data is redacted in responses:
+// either it is kept in a Secret, or it was rendered from one.
+func IsSecret(cloudinit *v1alpha1.CloudInit) bool {
+	return len(secretNames(cloudinit)) > 0
+}
+
+// secretNames returns the Secrets a caller must be allowed to read to see the
+// cloud-init's data.
+func secretNames(cloudinit *v1alpha1.CloudInit) []string {
+	if name, ok := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecret]; ok {
+		return []string{name}
+	}
+
+	names := []string{}
+	if data, ok := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecretSources]; ok {
+		if err := json.Unmarshal([]byte(data), &names); err != nil {
+			return []string{}
+		}
+	}
+
+	return names
+}
+
+// Spec returns the cloud-init's data, reading it from its Secret if it has one.
+func Spec(namespace string, cloudinit *v1alpha1.CloudInit) (v1alpha1.CloudInitSpec, error) {
+	name, ok := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecret]
+	if !ok {
+		return cloudinit.Spec, nil
+	}
+
+	secret, err := client.Kubernetes.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return v1alpha1.CloudInitSpec{}, err
+	}
+
+	return v1alpha1.CloudInitSpec{
+		UserData:    string(secret.Data[secretKeyUserData]),
+		NetworkData: string(secret.Data[secretKeyNetworkData]),
+	}, nil
+}
+
+// MarkSecret makes the cloud-init keep its data in a Secret. The spec is
+// cleared; the data has to be stored with SaveSecret once the cloud-init
+// exists.
+func MarkSecret(cloudinit *v1alpha1.CloudInit) {
+	if cloudinit.ObjectMeta.Annotations == nil {
+		cloudinit.ObjectMeta.Annotations = map[string]string{}
+	}
+	cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecret] = secretName(cloudinit.ObjectMeta.Name)
+	cloudinit.Spec = v1alpha1.CloudInitSpec{}
+}
+
+// MarkRenderedFrom records the Secrets a server's rendered cloud-init drew its
+// data from. The operator builds the VM from the spec, so the rendered data
+// stays there, but it is redacted like the Secrets it came from.
+func MarkRenderedFrom(cloudinit *v1alpha1.CloudInit, secrets []string) error {
+	if len(secrets) == 0 {
+		delete(cloudinit.ObjectMeta.Annotations, berth.AnnotationCloudInitSecretSources)
+		return nil
+	}
+
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	if cloudinit.ObjectMeta.Annotations == nil {
+		cloudinit.ObjectMeta.Annotations = map[string]string{}
+	}
+	cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecretSources] = string(data)
+
+	return nil
+}
+
+// SaveSecret creates or updates the Secret of a cloud-init. The Secret is
+// owned by the cloud-init so that it goes away with it.
+func SaveSecret(namespace string, cloudinit *v1alpha1.CloudInit, spec v1alpha1.CloudInitSpec) error {
+	name := cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitSecret]
+	controller := true
+	owner := metav1.OwnerReference{
+		APIVersion: berth.APIVersion,
+		Kind:       "CloudInit",
+		Name:       cloudinit.ObjectMeta.Name,
+		UID:        cloudinit.ObjectMeta.UID,
+		Controller: &controller,
+	}
+
+	data := map[string][]byte{
+		secretKeyUserData: []byte(spec.UserData),
+	}
+	if spec.NetworkData != "" {
+		data[secretKeyNetworkData] = []byte(spec.NetworkData)
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := client.Kubernetes.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       namespace,
+					OwnerReferences: []metav1.OwnerReference{owner},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: data,
+			}
+			_, err = client.Kubernetes.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		secret.ObjectMeta.OwnerReferences = []metav1.OwnerReference{owner}
+		secret.Data = data
+		_, err = client.Kubernetes.CoreV1().Secrets(namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// deleteSecret removes the Secret a cloud-init no longer uses, if any.
+func deleteSecret(namespace, name string) error {
+	err := client.Kubernetes.CoreV1().Secrets(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// visible reports whether the cloud-init's data may be shown to the caller. The
+// data of a secret cloud-init is redacted unless the caller asks for it with
+// ?reveal=true and may read the Secrets it comes from.
+func visible(ctx *gin.Context, namespace string, cloudinit *v1alpha1.CloudInit) (bool, error) {
+	names := secretNames(cloudinit)
+	if len(names) == 0 {
+		return true, nil
+	}
+
+	if ctx.Query("reveal") != "true" {
+		return false, nil
+	}
+
+	for _, name := range names {
+		if err := authz.CanGetSecret(ctx, namespace, name); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func revealError(ctx *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case authz.ErrUnauthenticated:
+		status = http.StatusUnauthorized
+	case authz.ErrForbidden:
+		status = http.StatusForbidden
+	}
+
+	ctx.JSON(status, gin.H{
+		"message": "reveal error: " + err.Error(),
+	})
+}
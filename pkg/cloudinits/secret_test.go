@@ -0,0 +1,52 @@
+package cloudinits
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+func TestSecretNames(t *testing.T) {
+	plain := &v1alpha1.CloudInit{
+		ObjectMeta: metav1.ObjectMeta{Name: "base"},
+		Spec:       v1alpha1.CloudInitSpec{UserData: "#cloud-config\n"},
+	}
+
+	stored := &v1alpha1.CloudInit{
+		ObjectMeta: metav1.ObjectMeta{Name: "passwords"},
+		Spec:       v1alpha1.CloudInitSpec{UserData: "#cloud-config\npassword: ubuntu\n"},
+	}
+	MarkSecret(stored)
+
+	if stored.Spec.UserData != "" {
+		t.Errorf("MarkSecret left user data %q in the spec", stored.Spec.UserData)
+	}
+
+	resolved := &Resolved{Sources: []*v1alpha1.CloudInit{plain, stored, stored}}
+	if got, want := resolved.SecretNames(), []string{"cloudinit-passwords"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretNames = %v, want %v", got, want)
+	}
+
+	rendered := &v1alpha1.CloudInit{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1-cloudinit"},
+		Spec:       v1alpha1.CloudInitSpec{UserData: "#cloud-config\npassword: ubuntu\n"},
+	}
+	if err := MarkRenderedFrom(rendered, resolved.SecretNames()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSecret(rendered) || rendered.Spec.UserData == "" {
+		t.Errorf("rendered copy: IsSecret = %t with user data %q, want the data kept and redacted", IsSecret(rendered), rendered.Spec.UserData)
+	}
+
+	if got, want := secretNames(rendered), []string{"cloudinit-passwords"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("secretNames of the rendered copy = %v, want %v", got, want)
+	}
+
+	if IsSecret(plain) {
+		t.Error("IsSecret of a plain cloudinit = true")
+	}
+}
@@ -0,0 +1,155 @@
+package cloudinits
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+// TemplateData is what user_data and network_data templates can refer to, e.g.
+// {{ .Hostname }} or {{ .Vars.ssh_key }}.
+type TemplateData struct {
+	Name       string
+	Hostname   string
+	MACAddress string
+	IP         string
+	Vars       map[string]string
+}
+
+// Only pure string helpers are offered so that templates cannot reach anything
+// outside of the data they are given.
+var templateFuncs = template.FuncMap{
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"quote":   strconv.Quote,
+	"indent":  indent,
+	"nindent": func(n int, s string) string { return "\n" + indent(n, s) },
+	"b64enc":  func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"join":    func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"split":   func(sep, s string) []string { return strings.Split(s, sep) },
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// isJinja reports whether cloud-init renders the part itself as a jinja
+// template on the VM.
+func isJinja(part Part) bool {
+	return part.ContentType == "text/jinja2" || strings.HasPrefix(part.Content, partContentTypes["text/jinja2"])
+}
+
+func hasJinja(parts []Part) bool {
+	for _, part := range parts {
+		if isJinja(part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTemplate reports whether the cloud-init has to be rendered per server.
+// Jinja templates, whole or as parts, use {{ }} too but are left for
+// cloud-init.
+func IsTemplate(spec v1alpha1.CloudInitSpec) bool {
+	if strings.Contains(spec.NetworkData, "{{") {
+		return true
+	}
+
+	parts, err := Parts(spec.UserData)
+	if err != nil {
+		return strings.Contains(spec.UserData, "{{")
+	}
+
+	for _, part := range parts {
+		if !isJinja(part) && strings.Contains(part.Content, "{{") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Render executes user_data and network_data as templates and validates the
+// result. Unknown variables are errors rather than empty strings, so that a
+// typo does not boot a broken VM.
+func Render(spec v1alpha1.CloudInitSpec, data *TemplateData) (v1alpha1.CloudInitSpec, validation.ErrorList) {
+	var errs validation.ErrorList
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+
+	userData, err := renderUserData(spec.UserData, data)
+	if err != nil {
+		errs = append(errs, validation.NewFieldError("user_data", err.Error()))
+	}
+
+	networkData, err := renderTemplate("network_data", spec.NetworkData, data)
+	if err != nil {
+		errs = append(errs, validation.NewFieldError("network_data", err.Error()))
+	}
+
+	rendered := v1alpha1.CloudInitSpec{
+		UserData:    userData,
+		NetworkData: networkData,
+	}
+
+	// Only what a template produces can be checked for cloud-init syntax.
+	if len(errs) == 0 {
+		errs = append(errs, ValidateUserData("user_data", rendered.UserData)...)
+		errs = append(errs, ValidateNetworkData("network_data", rendered.NetworkData)...)
+	}
+
+	return rendered, errs
+}
+
+// renderUserData renders user data as a template. Jinja parts are kept as
+// they are, so that only the other parts of a multipart are rendered.
+func renderUserData(text string, data *TemplateData) (string, error) {
+	parts, err := Parts(text)
+	if err != nil || !hasJinja(parts) {
+		return renderTemplate("user_data", text, data)
+	}
+
+	for i, part := range parts {
+		if isJinja(part) {
+			continue
+		}
+
+		parts[i].Content, err = renderTemplate(validation.Index("user_data", i), part.Content, data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return ComposeMultipart(parts)
+}
+
+func renderTemplate(name, text string, data *TemplateData) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
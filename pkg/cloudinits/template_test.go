@@ -0,0 +1,130 @@
+package cloudinits
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const jinjaUserData = `## template: jinja
+#cloud-config
+hostname: {{ v1.local_hostname }}
+{% if v1.region == 'tokyo' %}
+timezone: Asia/Tokyo
+{% endif %}
+`
+
+func TestIsTemplate(t *testing.T) {
+	jinjaPart, err := ComposeMultipart([]Part{
+		{ContentType: "text/jinja2", Content: "#cloud-config\nhostname: {{ v1.local_hostname }}\n"},
+		{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho hello\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec v1alpha1.CloudInitSpec
+		want bool
+	}{
+		{"plain", v1alpha1.CloudInitSpec{UserData: "#cloud-config\nhostname: test\n"}, false},
+		{"go template", v1alpha1.CloudInitSpec{UserData: "#cloud-config\nhostname: {{ .Hostname }}\n"}, true},
+		{"go template network data", v1alpha1.CloudInitSpec{NetworkData: "version: 2\n# {{ .IP }}\n"}, true},
+		{"jinja", v1alpha1.CloudInitSpec{UserData: jinjaUserData}, false},
+		{"jinja part", v1alpha1.CloudInitSpec{UserData: jinjaPart}, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTemplate(tt.spec); got != tt.want {
+			t.Errorf("IsTemplate(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSpecJinja(t *testing.T) {
+	if errs := ValidateSpec(v1alpha1.CloudInitSpec{UserData: jinjaUserData}); len(errs) > 0 {
+		t.Errorf("jinja user data: unexpected errs %v", errs)
+	}
+
+	// A Go template in network_data must not pull the jinja user_data
+	// through the Go template parser.
+	spec := v1alpha1.CloudInitSpec{
+		UserData:    jinjaUserData,
+		NetworkData: "version: 2\nethernets:\n  enp1s0:\n    addresses: [{{ .IP }}/24]\n",
+	}
+	if errs := ValidateSpec(spec); len(errs) > 0 {
+		t.Errorf("jinja user data with templated network data: unexpected errs %v", errs)
+	}
+
+	broken := v1alpha1.CloudInitSpec{UserData: "#cloud-config\nhostname: {{ .Hostname\n"}
+	if errs := ValidateSpec(broken); len(errs) != 1 || errs[0].Field != "user_data" {
+		t.Errorf("broken go template: errs = %v, want one error on user_data", errs)
+	}
+}
+
+func TestRenderJinja(t *testing.T) {
+	data := &TemplateData{Hostname: "test", IP: "192.0.2.10"}
+
+	spec, errs := Render(v1alpha1.CloudInitSpec{UserData: jinjaUserData}, data)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+
+	if spec.UserData != jinjaUserData {
+		t.Errorf("jinja user data was changed:\n%s", spec.UserData)
+	}
+
+	// Within a multipart only the parts that are not jinja are rendered.
+	jinjaPart := Part{ContentType: "text/jinja2", Content: "#cloud-config\nfqdn: {{ v1.local_hostname }}.example.com\n"}
+	userData, err := ComposeMultipart([]Part{
+		{ContentType: "text/cloud-config", Content: "#cloud-config\nhostname: {{ .Hostname }}\n"},
+		jinjaPart,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, errs = Render(v1alpha1.CloudInitSpec{UserData: userData}, data)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+
+	parts, err := Parts(spec.UserData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Part{
+		{ContentType: "text/cloud-config", Content: "#cloud-config\nhostname: test\n"},
+		jinjaPart,
+	}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %v, want %v", parts, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	spec, errs := Render(v1alpha1.CloudInitSpec{
+		UserData:    "#cloud-config\nhostname: {{ .Hostname | upper }}\npassword: {{ .Vars.password | quote }}\n",
+		NetworkData: "version: 2\nethernets:\n  enp1s0:\n    addresses: [{{ .IP }}/24]\n",
+	}, &TemplateData{Hostname: "test", IP: "192.0.2.10", Vars: map[string]string{"password": "ubuntu"}})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+
+	if spec.UserData != "#cloud-config\nhostname: TEST\npassword: \"ubuntu\"\n" {
+		t.Errorf("user data = %q", spec.UserData)
+	}
+
+	if !strings.Contains(spec.NetworkData, "[192.0.2.10/24]") {
+		t.Errorf("network data = %q", spec.NetworkData)
+	}
+
+	_, errs = Render(v1alpha1.CloudInitSpec{UserData: "#cloud-config\npassword: {{ .Vars.pasword }}\n"}, &TemplateData{})
+	if len(errs) != 1 || errs[0].Field != "user_data" {
+		t.Errorf("unknown variable: errs = %v, want one error on user_data", errs)
+	}
+}
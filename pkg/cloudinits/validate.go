@@ -0,0 +1,311 @@
+package cloudinits
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+// userDataHeaders are the first-line markers cloud-init uses to decide how to
+// handle user data. Anything else is silently ignored at boot.
+var userDataHeaders = []string{
+	"#cloud-config-archive",
+	"#cloud-config",
+	"#cloud-boothook",
+	"#part-handler",
+	"#include",
+	"## template: jinja",
+	"#!",
+}
+
+// partContentTypes are the MIME types cloud-init handles within a multipart
+// user data.
+var partContentTypes = map[string]string{
+	"text/cloud-config":         "#cloud-config",
+	"text/cloud-config-archive": "#cloud-config-archive",
+	"text/x-shellscript":        "#!",
+	"text/cloud-boothook":       "#cloud-boothook",
+	"text/part-handler":         "#part-handler",
+	"text/x-include-url":        "#include",
+	"text/jinja2":               "## template: jinja",
+}
+
+var yamlLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// ValidateSpec checks user_data and network_data before they are stored. A
+// template is only checked for syntax here; its output is validated when it is
+// rendered for a server.
+func ValidateSpec(spec v1alpha1.CloudInitSpec) validation.ErrorList {
+	var errs validation.ErrorList
+	if IsTemplate(spec) {
+		errs = append(errs, validateUserDataSyntax("user_data", spec.UserData)...)
+		errs = append(errs, validateTemplateSyntax("network_data", spec.NetworkData)...)
+		return errs
+	}
+
+	errs = append(errs, ValidateUserData("user_data", spec.UserData)...)
+	errs = append(errs, ValidateNetworkData("network_data", spec.NetworkData)...)
+
+	return errs
+}
+
+// validateUserDataSyntax checks the template syntax of user data, leaving
+// jinja parts to cloud-init.
+func validateUserDataSyntax(field, text string) validation.ErrorList {
+	parts, err := Parts(text)
+	if err != nil || !hasJinja(parts) {
+		return validateTemplateSyntax(field, text)
+	}
+
+	var errs validation.ErrorList
+	for i, part := range parts {
+		if !isJinja(part) {
+			errs = append(errs, validateTemplateSyntax(validation.Index(field, i), part.Content)...)
+		}
+	}
+
+	return errs
+}
+
+func validateTemplateSyntax(field, text string) validation.ErrorList {
+	var errs validation.ErrorList
+	if _, err := template.New(field).Funcs(templateFuncs).Parse(text); err != nil {
+		errs = append(errs, validation.NewFieldError(field, err.Error()))
+	}
+
+	return errs
+}
+
+// ValidateUserData requires a header cloud-init recognizes and, for
+// #cloud-config, a YAML mapping.
+func ValidateUserData(field, data string) validation.ErrorList {
+	var errs validation.ErrorList
+	if data == "" {
+		return errs
+	}
+
+	if isMultipart(data) {
+		return validateMultipart(field, data)
+	}
+
+	firstLine := strings.SplitN(data, "\n", 2)[0]
+	header := ""
+	for _, h := range userDataHeaders {
+		if strings.HasPrefix(firstLine, h) {
+			header = h
+			break
+		}
+	}
+
+	switch header {
+	case "":
+		errs = append(errs, validation.NewPositionError(field, 1, 1, "must start with #cloud-config, #! or a MIME multipart header"))
+	case "#cloud-config":
+		if !isCloudConfig(data) {
+			errs = append(errs, validation.NewPositionError(field, 1, 1, "unknown header "+strconv.Quote(firstLine)))
+			break
+		}
+		errs = append(errs, validateCloudConfig(field, data)...)
+	}
+
+	return errs
+}
+
+// isCloudConfig reports whether the first line of data is exactly the
+// #cloud-config header, which #cloud-config-archive also starts with.
+func isCloudConfig(data string) bool {
+	return strings.TrimRight(strings.SplitN(data, "\n", 2)[0], " \t\r") == "#cloud-config"
+}
+
+func isMultipart(data string) bool {
+	firstLine := strings.ToLower(strings.SplitN(data, "\n", 2)[0])
+	return strings.HasPrefix(firstLine, "content-type: multipart/") || strings.HasPrefix(firstLine, "mime-version:")
+}
+
+func validateMultipart(field, data string) validation.ErrorList {
+	var errs validation.ErrorList
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		return append(errs, validation.NewFieldError(field, "invalid MIME message: "+err.Error()))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return append(errs, validation.NewFieldError(field, "Content-Type must be multipart/mixed"))
+	}
+
+	if params["boundary"] == "" {
+		return append(errs, validation.NewFieldError(field, "Content-Type has no boundary"))
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			if i == 0 {
+				errs = append(errs, validation.NewFieldError(field, "has no parts"))
+			}
+			break
+		}
+
+		if err != nil {
+			errs = append(errs, validation.NewFieldError(validation.Index(field, i), "invalid part: "+err.Error()))
+			break
+		}
+
+		errs = append(errs, validatePart(validation.Index(field, i), part)...)
+	}
+
+	return errs
+}
+
+func validatePart(field string, part *multipart.Part) validation.ErrorList {
+	var errs validation.ErrorList
+	contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return append(errs, validation.NewFieldError(field, "invalid Content-Type: "+err.Error()))
+	}
+
+	header, ok := partContentTypes[contentType]
+	if !ok {
+		return append(errs, validation.NewFieldError(field, "unsupported Content-Type "+contentType))
+	}
+
+	var body io.Reader = part
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, part)
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return append(errs, validation.NewFieldError(field, "unreadable: "+err.Error()))
+	}
+
+	// The header is optional within a part since the Content-Type says what
+	// it is.
+	if header == "#cloud-config" {
+		errs = append(errs, validateCloudConfig(field, string(content))...)
+	}
+
+	return errs
+}
+
+func validateCloudConfig(field, data string) validation.ErrorList {
+	doc, errs := parseYAML(field, data)
+	if doc == nil {
+		return errs
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return append(errs, nodeError(field, root, "cloud-config must be a mapping"))
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "packages", "runcmd", "bootcmd", "ssh_authorized_keys", "users", "mounts":
+			errs = append(errs, expectKind(validation.Child(field, key.Value), value, yaml.SequenceNode)...)
+		case "write_files":
+			errs = append(errs, validateWriteFiles(validation.Child(field, key.Value), value)...)
+		case "chpasswd", "ssh_keys", "apt", "growpart", "power_state":
+			errs = append(errs, expectKind(validation.Child(field, key.Value), value, yaml.MappingNode)...)
+		case "hostname", "fqdn", "timezone", "locale", "password":
+			errs = append(errs, expectKind(validation.Child(field, key.Value), value, yaml.ScalarNode)...)
+		}
+	}
+
+	return errs
+}
+
+func validateWriteFiles(field string, node *yaml.Node) validation.ErrorList {
+	if errs := expectKind(field, node, yaml.SequenceNode); len(errs) > 0 {
+		return errs
+	}
+
+	var errs validation.ErrorList
+	for i, file := range node.Content {
+		f := validation.Index(field, i)
+		if e := expectKind(f, file, yaml.MappingNode); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+
+		if mappingValue(file, "path") == nil {
+			errs = append(errs, nodeError(validation.Child(f, "path"), file, "must be specified"))
+		}
+	}
+
+	return errs
+}
+
+// parseYAML returns the document node, or nil when the data is empty or does
+// not parse.
+func parseYAML(field, data string) (*yaml.Node, validation.ErrorList) {
+	var errs validation.ErrorList
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		msg := err.Error()
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, append(errs, validation.NewPositionError(field, line, 0, m[2]))
+		}
+		return nil, append(errs, validation.NewFieldError(field, strings.TrimPrefix(msg, "yaml: ")))
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, errs
+	}
+
+	return &doc, errs
+}
+
+func nodeError(field string, node *yaml.Node, message string) *validation.FieldError {
+	return validation.NewPositionError(field, node.Line, node.Column, message)
+}
+
+var kindNames = map[yaml.Kind]string{
+	yaml.SequenceNode: "a list",
+	yaml.MappingNode:  "a mapping",
+	yaml.ScalarNode:   "a scalar",
+}
+
+func expectKind(field string, node *yaml.Node, kind yaml.Kind) validation.ErrorList {
+	var errs validation.ErrorList
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if node.Kind != kind {
+		errs = append(errs, nodeError(field, node, "must be "+kindNames[kind]))
+	}
+
+	return errs
+}
+
+// mappingValue returns the value for key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package cloudinits
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUserData(t *testing.T) {
+	multipart := func(parts ...string) string {
+		return "Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n" + strings.Join(parts, "") + "--b--\n"
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		field   string
+		line    int
+		column  int
+		message string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "cloud-config",
+			data: "#cloud-config\nhostname: test\npackages:\n  - curl\nwrite_files:\n  - path: /etc/motd\n    content: hello\n",
+		},
+		{
+			name: "script",
+			data: "#!/bin/sh\necho hello\n",
+		},
+		{
+			name: "jinja template",
+			data: "## template: jinja\n#cloud-config\nhostname: {{ v1.local_hostname }}\n",
+		},
+		{
+			name:    "no header",
+			data:    "hostname: test\n",
+			field:   "user_data",
+			line:    1,
+			column:  1,
+			message: "must start with #cloud-config",
+		},
+		{
+			name:    "header with trailing text",
+			data:    "#cloud-configuration\nhostname: test\n",
+			field:   "user_data",
+			line:    1,
+			column:  1,
+			message: "unknown header",
+		},
+		{
+			name:    "yaml syntax error",
+			data:    "#cloud-config\nhostname: test\npackages: curl: jq\n",
+			field:   "user_data",
+			line:    3,
+			message: "mapping values are not allowed",
+		},
+		{
+			name:    "not a mapping",
+			data:    "#cloud-config\n- curl\n",
+			field:   "user_data",
+			line:    2,
+			column:  1,
+			message: "cloud-config must be a mapping",
+		},
+		{
+			name:    "list expected",
+			data:    "#cloud-config\nhostname: test\nruncmd: echo hello\n",
+			field:   "user_data.runcmd",
+			line:    3,
+			column:  9,
+			message: "must be a list",
+		},
+		{
+			name:    "write_files without path",
+			data:    "#cloud-config\nwrite_files:\n  - content: hello\n",
+			field:   "user_data.write_files[0].path",
+			line:    3,
+			column:  5,
+			message: "must be specified",
+		},
+		{
+			name: "multipart",
+			data: multipart(
+				"--b\nContent-Type: text/x-shellscript\n\n#!/bin/sh\n",
+				"--b\nContent-Type: text/cloud-config\n\nhostname: test\n",
+			),
+		},
+		{
+			name: "multipart with an invalid cloud-config part",
+			data: multipart(
+				"--b\nContent-Type: text/x-shellscript\n\n#!/bin/sh\n",
+				"--b\nContent-Type: text/cloud-config\n\n#cloud-config\npackages: curl\n",
+			),
+			field:   "user_data[1].packages",
+			line:    2,
+			column:  11,
+			message: "must be a list",
+		},
+		{
+			name:    "multipart with an unsupported part",
+			data:    multipart("--b\nContent-Type: application/json\n\n{}\n"),
+			field:   "user_data[0]",
+			message: "unsupported Content-Type application/json",
+		},
+		{
+			name:    "multipart without parts",
+			data:    "Content-Type: multipart/mixed; boundary=\"b\"\r\nMIME-Version: 1.0\r\n\r\n--b--\r\n",
+			field:   "user_data",
+			message: "has no parts",
+		},
+		{
+			name:    "multipart without a boundary",
+			data:    "Content-Type: multipart/mixed\nMIME-Version: 1.0\n\n",
+			field:   "user_data",
+			message: "Content-Type has no boundary",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateUserData("user_data", tt.data)
+			if tt.field == "" {
+				if len(errs) > 0 {
+					t.Errorf("unexpected errs %v", errs)
+				}
+				return
+			}
+
+			if len(errs) != 1 {
+				t.Fatalf("errs = %v, want one error on %s", errs, tt.field)
+			}
+
+			e := errs[0]
+			if e.Field != tt.field || e.Line != tt.line || e.Column != tt.column || !strings.Contains(e.Message, tt.message) {
+				t.Errorf("error %s %d:%d %q, want %s %d:%d %q", e.Field, e.Line, e.Column, e.Message, tt.field, tt.line, tt.column, tt.message)
+			}
+		})
+	}
+}
@@ -3,43 +3,165 @@ package disks
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
 type ResponseDisk struct {
-	Name       string `json:"name"`
-	Size       string `json:"size"`
-	State      string `json:"state"`
-	AttachedTo string `json:"attachedTo"`
+	Name         string          `json:"name"`
+	Size         string          `json:"size"`
+	Capacity     string          `json:"capacity"`
+	StorageClass string          `json:"storage_class"`
+	VolumeMode   string          `json:"volume_mode"`
+	AccessMode   string          `json:"access_mode"`
+	Source       *ResponseSource `json:"source"`
+	State        string          `json:"state"`
+	Progress     string          `json:"progress"`
+	AttachedTo   string          `json:"attachedTo"`
+	CreatedAt    string          `json:"created_at"`
 }
 
+type ResponseSource struct {
+	Archive  *berth.AttachedArchive `json:"archive,omitempty"`
+	Disk     *berth.AttachedDisk    `json:"disk,omitempty"`
+	Snapshot *AttachedSnapshot      `json:"snapshot,omitempty"`
+}
+
+// RequestDisk has no storage class, volume mode or access mode: the operator
+// provisions every DataVolume with the cluster defaults and cannot be told
+// otherwise, and disks created from a snapshot take the settings of the
+// snapshot's volume. Responses report what the volume ended up with, and
+// GET /storageclasses lists what the cluster offers.
 type RequestDisk struct {
-	Name   string                `json:"name"    binding:"required"`
-	Size   string                `json:"size"    binding:"required"`
-	Source *berth.AttachedSource `json:"source"`
+	Name   string         `json:"name"   binding:"required"`
+	Size   string         `json:"size"   binding:"required"`
+	Source *RequestSource `json:"source"`
+}
+
+type RequestSource struct {
+	Archive  *berth.AttachedArchive `json:"archive"`
+	Disk     *berth.AttachedDisk    `json:"disk"`
+	Snapshot *AttachedSnapshot      `json:"snapshot"`
 }
 
 func convertDisk2ResponseDisk(disk v1alpha1.Disk) *ResponseDisk {
 	ret := &ResponseDisk{
 		Name:       disk.ObjectMeta.Name,
 		Size:       disk.Spec.Size,
+		Source:     &ResponseSource{},
 		State:      disk.Status.State,
 		AttachedTo: disk.Status.AttachedTo,
+		CreatedAt:  disk.ObjectMeta.CreationTimestamp.UTC().Format(time.RFC3339),
+	}
+
+	if disk.Spec.Source != nil {
+		ret.Source.Archive = disk.Spec.Source.Archive
+		ret.Source.Disk = disk.Spec.Source.Disk
+	}
+
+	if snapshot := disk.ObjectMeta.Annotations[berth.AnnotationSourceSnapshot]; snapshot != "" {
+		ret.Source.Snapshot = &AttachedSnapshot{Name: snapshot}
 	}
 
 	return ret
 }
 
+func validateRequestDisk(d *RequestDisk) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", d.Name)...)
+	errs = append(errs, validation.ValidateQuantityString("size", d.Size)...)
+
+	if d.Source != nil {
+		sources := 0
+		for _, set := range []bool{d.Source.Archive != nil, d.Source.Disk != nil, d.Source.Snapshot != nil} {
+			if set {
+				sources++
+			}
+		}
+
+		if sources > 1 {
+			errs = append(errs, validation.NewFieldError("source", "only one of archive, disk or snapshot may be specified"))
+		}
+
+		if d.Source.Archive != nil {
+			errs = append(errs, validation.ValidateName("source.archive.name", d.Source.Archive.Name)...)
+		}
+
+		if d.Source.Disk != nil {
+			errs = append(errs, validation.ValidateName("source.disk.name", d.Source.Disk.Name)...)
+			if d.Source.Disk.Name == d.Name {
+				errs = append(errs, validation.NewFieldError("source.disk.name", "disk cannot be its own source"))
+			}
+		}
+
+		if d.Source.Snapshot != nil {
+			errs = append(errs, validation.ValidateName("source.snapshot.name", d.Source.Snapshot.Name)...)
+		}
+	}
+
+	return errs
+}
+
+func convertRequestSource(requested *RequestSource) *berth.AttachedSource {
+	if requested == nil {
+		return nil
+	}
+
+	source := &berth.AttachedSource{}
+
+	if requested.Archive != nil {
+		source.Archive = &berth.AttachedArchive{
+			Name: requested.Archive.Name,
+		}
+	}
+
+	if requested.Disk != nil {
+		source.Disk = &berth.AttachedDisk{
+			Name: requested.Disk.Name,
+		}
+	}
+
+	// A disk restored from a snapshot has no source for the operator to
+	// populate it from.
+	if source.Archive == nil && source.Disk == nil {
+		return nil
+	}
+
+	return source
+}
+
 func GetAllDisks(ctx *gin.Context) {
 	namespace := "kubeberth"
-	disks, err := client.Clientset.Disks().Disks(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	disks, err := client.Clientset.Disks().Disks(namespace).List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
 
+	volumes, err := listVolumeStatuses(namespace)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
@@ -47,12 +169,14 @@ func GetAllDisks(ctx *gin.Context) {
 		return
 	}
 
-	var ret []*ResponseDisk
+	ret := []*ResponseDisk{}
 	for _, disk := range disks.Items {
-		ret = append(ret, convertDisk2ResponseDisk(disk))
+		r := convertDisk2ResponseDisk(disk)
+		r.setVolumeStatus(volumes[disk.ObjectMeta.Name])
+		ret = append(ret, r)
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, disks.ListMeta))
 }
 
 func GetDisk(ctx *gin.Context) {
@@ -67,7 +191,18 @@ func GetDisk(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertDisk2ResponseDisk(*disk))
+	volume, err := getVolumeStatus(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := convertDisk2ResponseDisk(*disk)
+	ret.setVolumeStatus(volume)
+
+	ctx.JSON(http.StatusOK, ret)
 }
 
 func CreateDisk(ctx *gin.Context) {
@@ -79,26 +214,18 @@ func CreateDisk(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateRequestDisk(&d); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := d.Name
 	namespace := "kubeberth"
 	size := d.Size
-	var source *berth.AttachedSource
-
-	if d.Source != nil {
-		source = &berth.AttachedSource{}
-
-		if d.Source.Archive != nil {
-			source.Archive = &berth.AttachedArchive{
-				Name: d.Source.Archive.Name,
-			}
-		}
-
-		if d.Source.Disk != nil {
-			source.Disk = &berth.AttachedDisk{
-				Name: d.Source.Disk.Name,
-			}
-		}
-	}
+	source := convertRequestSource(d.Source)
 
 	disk := &v1alpha1.Disk{
 		ObjectMeta: metav1.ObjectMeta{
@@ -111,7 +238,45 @@ func CreateDisk(ctx *gin.Context) {
 		},
 	}
 
-	ret, err := client.Clientset.Disks().Disks(namespace).Create(context.TODO(), disk, metav1.CreateOptions{})
+	errs, err := references.ValidateDiskSourceReferences(namespace, disk.Spec.Source)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	var snapshot *unstructured.Unstructured
+	if d.Source != nil && d.Source.Snapshot != nil {
+		snapshot, err = getSnapshot(namespace, d.Source.Snapshot.Name)
+		switch {
+		case apierrors.IsNotFound(err):
+			errs = append(errs, validation.NewFieldError("source.snapshot.name", "snapshot "+d.Source.Snapshot.Name+" does not exist"))
+		case err != nil:
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		default:
+			errs = append(errs, validateSnapshot("source.snapshot.name", snapshot, "size", size)...)
+		}
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	var ret *v1alpha1.Disk
+	if snapshot != nil {
+		ret, err = createDiskFromSnapshot(namespace, disk, snapshot)
+	} else {
+		ret, err = client.Clientset.Disks().Disks(namespace).Create(context.TODO(), disk, metav1.CreateOptions{})
+	}
+
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
@@ -131,10 +296,18 @@ func UpdateDisk(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateRequestDisk(&d); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := d.Name
 	namespace := "kubeberth"
 	size := d.Size
-	archiveName := d.Source.Archive.Name
+	source := convertRequestSource(d.Source)
 	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 
 	if err != nil {
@@ -144,17 +317,42 @@ func UpdateDisk(ctx *gin.Context) {
 		return
 	}
 
+	errs := validateSizeChange("size", disk.Spec.Size, size)
+	if d.Source != nil && d.Source.Snapshot != nil && d.Source.Snapshot.Name != disk.ObjectMeta.Annotations[berth.AnnotationSourceSnapshot] {
+		errs = append(errs, validation.NewFieldError("source.snapshot.name", "cannot be changed; restore the disk from the snapshot instead"))
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	spec := v1alpha1.DiskSpec{
-		Size: size,
-		Source: &berth.AttachedSource{
-			Archive: &berth.AttachedArchive{
-				Name: archiveName,
-			},
-		},
+		Size:   size,
+		Source: source,
 	}
 
 	disk.Spec = spec
 
+	errs, err = references.ValidateDiskSourceReferences(namespace, disk.Spec.Source)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	ret, err := client.Clientset.Disks().Disks(namespace).Update(context.TODO(), disk, metav1.UpdateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -169,6 +367,25 @@ func UpdateDisk(ctx *gin.Context) {
 func DeleteDisk(ctx *gin.Context) {
 	name := ctx.Param("name")
 	namespace := "kubeberth"
+
+	if ctx.Query("force") != "true" {
+		refs, err := references.ReferencesToDisk(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if len(refs) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":       "disk " + name + " is still referenced",
+				"referenced_by": refs,
+			})
+			return
+		}
+	}
+
 	err := client.Clientset.Disks().Disks(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 
 	if err != nil {
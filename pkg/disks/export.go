@@ -0,0 +1,153 @@
+package disks
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/export"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/httprange"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+func ExportDisk(ctx *gin.Context) {
+	if export.DefaultProxy == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": "error: export proxy is not configured",
+		})
+		return
+	}
+
+	format := ctx.DefaultQuery("format", export.FormatRaw)
+	compression := ctx.Query("compression")
+	rangeHeader := ctx.GetHeader("Range")
+
+	var errs validation.ErrorList
+	if format != export.FormatRaw && format != export.FormatQCOW2 {
+		errs = append(errs, validation.NewFieldError("format", "must be raw or qcow2"))
+	}
+
+	if compression != "" && compression != "gzip" {
+		errs = append(errs, validation.NewFieldError("compression", "must be gzip"))
+	}
+
+	if compression != "" && rangeHeader != "" {
+		errs = append(errs, validation.NewFieldError("Range", "cannot be combined with compression"))
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	var offset, length int64 = 0, -1
+	if rangeHeader != "" {
+		var err error
+		offset, length, err = httprange.ParseRange(rangeHeader)
+		if err != nil {
+			ctx.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if ctx.Query("force") != "true" {
+		running, err := attachedToRunningServer(namespace, disk)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if running {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "disk " + name + " is attached to running server " + disk.Status.AttachedTo + "; stop it first or set force=true",
+			})
+			return
+		}
+	}
+
+	target := export.Target{
+		Namespace: namespace,
+		Disk:      name,
+		Format:    format,
+	}
+
+	stream, err := export.DefaultProxy.Open(ctx.Request.Context(), target, offset, length)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": "export error: " + err.Error(),
+		})
+		return
+	}
+	defer stream.Body.Close()
+
+	filename := name + "." + format
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", "application/octet-stream")
+
+	if compression == "gzip" {
+		filename += ".gz"
+		header.Set("Content-Type", "application/gzip")
+		header.Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		ctx.Status(http.StatusOK)
+
+		gz := gzip.NewWriter(ctx.Writer)
+		if _, err := io.Copy(gz, stream.Body); err != nil {
+			klog.Errorf("export %s: %s", name, err.Error())
+			return
+		}
+
+		if err := gz.Close(); err != nil {
+			klog.Errorf("export %s: %s", name, err.Error())
+		}
+		return
+	}
+
+	header.Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	header.Set("Accept-Ranges", "bytes")
+	if stream.Length >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(stream.Length, 10))
+	}
+
+	status := http.StatusOK
+	if rangeHeader != "" {
+		status = http.StatusPartialContent
+		size := "*"
+		if stream.Size >= 0 {
+			size = strconv.FormatInt(stream.Size, 10)
+		}
+		if stream.Length >= 0 {
+			header.Set("Content-Range", "bytes "+strconv.FormatInt(stream.Offset, 10)+"-"+strconv.FormatInt(stream.Offset+stream.Length-1, 10)+"/"+size)
+		}
+	}
+
+	ctx.Status(status)
+	if _, err := io.Copy(ctx.Writer, stream.Body); err != nil {
+		klog.Errorf("export %s: %s", name, err.Error())
+	}
+}
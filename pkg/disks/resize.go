@@ -0,0 +1,212 @@
+package disks
+
+import (
+	"context"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/quota"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestResizeDisk struct {
+	Size  string `json:"size"  binding:"required"`
+	Force bool   `json:"force"`
+}
+
+type ResponseResizeDisk struct {
+	Disk      *ResponseDisk      `json:"disk"`
+	Previous  string             `json:"previous_size"`
+	Expansion *ResponseExpansion `json:"expansion"`
+	Warnings  []string           `json:"warnings,omitempty"`
+}
+
+type ResponseExpansion struct {
+	Requested  string   `json:"requested"`
+	Capacity   string   `json:"capacity"`
+	Conditions []string `json:"conditions"`
+}
+
+// validateSizeChange only accepts sizes that keep or grow the disk, since
+// volumes cannot be shrunk in place.
+func validateSizeChange(field, current, desired string) validation.ErrorList {
+	var errs validation.ErrorList
+	currentSize, err := resource.ParseQuantity(current)
+	if err != nil {
+		return errs
+	}
+
+	desiredSize, err := resource.ParseQuantity(desired)
+	if err != nil {
+		return append(errs, validation.NewFieldError(field, "invalid quantity: "+desired))
+	}
+
+	if desiredSize.Cmp(currentSize) < 0 {
+		errs = append(errs, validation.NewFieldError(field, "disks can only grow (current size "+current+")"))
+	}
+
+	return errs
+}
+
+func attachedToRunningServer(namespace string, disk *v1alpha1.Disk) (bool, error) {
+	if disk.Status.AttachedTo == "" {
+		return false, nil
+	}
+
+	server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), disk.Status.AttachedTo, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return server.Spec.Running != nil && *server.Spec.Running, nil
+}
+
+func getExpansion(namespace, name string) (*ResponseExpansion, error) {
+	ret := &ResponseExpansion{
+		Conditions: []string{},
+	}
+
+	pvc, err := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		ret.Requested = requested.String()
+	}
+
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		ret.Capacity = capacity.String()
+	}
+
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue {
+			ret.Conditions = append(ret.Conditions, string(condition.Type))
+		}
+	}
+
+	return ret, nil
+}
+
+func ResizeDisk(ctx *gin.Context) {
+	var r RequestResizeDisk
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validation.ValidateQuantityString("size", r.Size); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	previous := disk.Spec.Size
+	errs := validateSizeChange("size", previous, r.Size)
+	if len(errs) == 0 {
+		delta := resource.MustParse(r.Size)
+		if current, err := resource.ParseQuantity(previous); err == nil {
+			delta.Sub(current)
+		}
+
+		e, err := quota.Check(namespace, "size", corev1.ResourceStorage, delta)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+		errs = append(errs, e...)
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	var warnings []string
+	running, err := attachedToRunningServer(namespace, disk)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if running {
+		if !r.Force {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "disk " + name + " is attached to running server " + disk.Status.AttachedTo + "; stop it first or set force",
+			})
+			return
+		}
+		warnings = append(warnings, "disk is attached to running server "+disk.Status.AttachedTo+"; the guest may not see the new size until it is restarted")
+	}
+
+	var ret *v1alpha1.Disk
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		disk.Spec.Size = r.Size
+		ret, err = client.Clientset.Disks().Disks(namespace).Update(context.TODO(), disk, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	expansion, err := getExpansion(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &ResponseResizeDisk{
+		Disk:      convertDisk2ResponseDisk(*ret),
+		Previous:  previous,
+		Expansion: expansion,
+		Warnings:  warnings,
+	})
+}
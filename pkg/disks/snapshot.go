@@ -0,0 +1,438 @@
+package disks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const (
+	volumePollInterval = 3 * time.Second
+	volumeTimeout      = 5 * time.Minute
+)
+
+var volumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+type AttachedSnapshot struct {
+	Name string `json:"name"`
+}
+
+type ResponseSnapshot struct {
+	Name          string `json:"name"`
+	Disk          string `json:"disk"`
+	SnapshotClass string `json:"snapshot_class"`
+	Ready         bool   `json:"ready"`
+	Size          string `json:"size"`
+	CreatedAt     string `json:"created_at"`
+	Error         string `json:"error,omitempty"`
+}
+
+type RequestSnapshot struct {
+	Name          string `json:"name"`
+	SnapshotClass string `json:"snapshot_class"`
+}
+
+type RequestRestoreDisk struct {
+	Snapshot string `json:"snapshot" binding:"required"`
+}
+
+func convertVolumeSnapshot2ResponseSnapshot(snapshot unstructured.Unstructured) *ResponseSnapshot {
+	ret := &ResponseSnapshot{
+		Name: snapshot.GetName(),
+		Disk: snapshot.GetLabels()[berth.LabelDisk],
+	}
+
+	ret.SnapshotClass, _, _ = unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+	ret.Ready, _, _ = unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	ret.Size, _, _ = unstructured.NestedString(snapshot.Object, "status", "restoreSize")
+	ret.CreatedAt, _, _ = unstructured.NestedString(snapshot.Object, "status", "creationTime")
+	ret.Error, _, _ = unstructured.NestedString(snapshot.Object, "status", "error", "message")
+
+	return ret
+}
+
+// getSnapshot returns a snapshot taken through this API, of any disk.
+func getSnapshot(namespace, name string) (*unstructured.Unstructured, error) {
+	snapshot, err := client.Dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot.GetLabels()[berth.LabelDisk] == "" {
+		return nil, apierrors.NewNotFound(volumeSnapshotResource.GroupResource(), name)
+	}
+
+	return snapshot, nil
+}
+
+func getDiskSnapshot(namespace, disk, name string) (*unstructured.Unstructured, error) {
+	snapshot, err := getSnapshot(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot.GetLabels()[berth.LabelDisk] != disk {
+		return nil, apierrors.NewNotFound(volumeSnapshotResource.GroupResource(), name)
+	}
+
+	return snapshot, nil
+}
+
+// validateSnapshot checks that a disk of the given size can be populated from
+// the snapshot.
+func validateSnapshot(field string, snapshot *unstructured.Unstructured, sizeField, size string) validation.ErrorList {
+	var errs validation.ErrorList
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); !ready {
+		errs = append(errs, validation.NewFieldError(field, "snapshot "+snapshot.GetName()+" is not ready to use"))
+	}
+
+	restoreSize, _, _ := unstructured.NestedString(snapshot.Object, "status", "restoreSize")
+	if restoreSize == "" {
+		return errs
+	}
+
+	minimum, err := resource.ParseQuantity(restoreSize)
+	if err != nil {
+		return errs
+	}
+
+	if desired, err := resource.ParseQuantity(size); err == nil && desired.Cmp(minimum) < 0 {
+		errs = append(errs, validation.NewFieldError(sizeField, "must be at least the size of snapshot "+snapshot.GetName()+" ("+restoreSize+")"))
+	}
+
+	return errs
+}
+
+func GetAllSnapshots(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	opts.LabelSelector = berth.LabelDisk + "=" + name
+	snapshots, err := client.Dynamic.Resource(volumeSnapshotResource).Namespace(namespace).List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := []*ResponseSnapshot{}
+	for _, snapshot := range snapshots.Items {
+		ret = append(ret, convertVolumeSnapshot2ResponseSnapshot(snapshot))
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(ret, metav1.ListMeta{
+		Continue:        snapshots.GetContinue(),
+		ResourceVersion: snapshots.GetResourceVersion(),
+	}))
+}
+
+func GetSnapshot(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	snapshot, err := getDiskSnapshot(namespace, name, ctx.Param("snapshot"))
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertVolumeSnapshot2ResponseSnapshot(*snapshot))
+}
+
+func CreateSnapshot(ctx *gin.Context) {
+	var s RequestSnapshot
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&s); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "request invalid: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	snapshotName := s.Name
+	if snapshotName == "" {
+		snapshotName = name + "-snapshot-" + utilrand.String(5)
+	}
+
+	if errs := validation.ValidateName("name", snapshotName); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	if _, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	pvc, err := diskVolumeClaim(namespace, name)
+	if apierrors.IsNotFound(err) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "disk " + name + " has no volume to snapshot yet",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvc.ObjectMeta.Name,
+		},
+	}
+
+	if s.SnapshotClass != "" {
+		spec["volumeSnapshotClassName"] = s.SnapshotClass
+	}
+
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": volumeSnapshotResource.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      snapshotName,
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					berth.LabelDisk: name,
+				},
+				"annotations": volumeAnnotations(pvc),
+			},
+			"spec": spec,
+		},
+	}
+
+	ret, err := client.Dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Create(context.TODO(), snapshot, metav1.CreateOptions{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, convertVolumeSnapshot2ResponseSnapshot(*ret))
+}
+
+func DeleteSnapshot(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	snapshotName := ctx.Param("snapshot")
+
+	if _, err := getDiskSnapshot(namespace, name, snapshotName); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	err := client.Dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Delete(context.TODO(), snapshotName, metav1.DeleteOptions{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "ok",
+	})
+}
+
+// volumeAnnotations records the settings of a snapshot's volume, so that
+// volumes restored from it match even once the disk is gone.
+func volumeAnnotations(pvc *corev1.PersistentVolumeClaim) map[string]interface{} {
+	ret := map[string]interface{}{}
+	v := convertPVC2VolumeStatus(pvc)
+
+	for key, value := range map[string]string{
+		berth.AnnotationStorageClass: v.storageClass,
+		berth.AnnotationVolumeMode:   v.volumeMode,
+		berth.AnnotationAccessMode:   v.accessMode,
+	} {
+		if value != "" {
+			ret[key] = value
+		}
+	}
+
+	return ret
+}
+
+// RestoreDisk rebuilds a disk's volume from one of its snapshots. The volume
+// cannot be swapped under the operator's DataVolume, so the disk is deleted
+// and created again from the snapshot with the same name and size.
+func RestoreDisk(ctx *gin.Context) {
+	var r RequestRestoreDisk
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	running, err := attachedToRunningServer(namespace, disk)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if running {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "disk " + name + " is attached to running server " + disk.Status.AttachedTo + "; stop it before restoring",
+		})
+		return
+	}
+
+	var errs validation.ErrorList
+	snapshot, err := getDiskSnapshot(namespace, name, r.Snapshot)
+	switch {
+	case apierrors.IsNotFound(err):
+		errs = append(errs, validation.NewFieldError("snapshot", "snapshot "+r.Snapshot+" of disk "+name+" does not exist"))
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	default:
+		errs = validateSnapshot("snapshot", snapshot, "snapshot", disk.Spec.Size)
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	// The operation outlives the disk it restores, so the disk does not own it.
+	op, err := operations.Start(namespace, "restore", "disks/"+name, nil)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, op)
+
+	go restoreDisk(op, namespace, disk, snapshot)
+}
+
+func restoreDisk(op *operations.Operation, namespace string, disk *v1alpha1.Disk, snapshot *unstructured.Unstructured) {
+	name := disk.ObjectMeta.Name
+
+	op.SetStep("deleting volume")
+	err := client.Clientset.Disks().Disks(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil {
+		op.Fail(err)
+		return
+	}
+
+	if err := waitForVolumeDeleted(namespace, name); err != nil {
+		op.Fail(fmt.Errorf("disk %s was deleted, but its volume is still there: %s", name, err.Error()))
+		return
+	}
+
+	// Annotations describe the old contents, such as an upload, so only the
+	// labels are kept.
+	restored := &v1alpha1.Disk{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    disk.ObjectMeta.Labels,
+		},
+		Spec: v1alpha1.DiskSpec{
+			Size: disk.Spec.Size,
+		},
+	}
+
+	op.SetStep("restoring")
+	if _, err := createDiskFromSnapshot(namespace, restored, snapshot); err != nil {
+		op.Fail(fmt.Errorf("disk %s was deleted, but could not be created again from snapshot %s: %s", name, snapshot.GetName(), err.Error()))
+		return
+	}
+
+	klog.Infof("disk %s restored from snapshot %s", name, snapshot.GetName())
+	op.Succeed("restored from snapshot " + snapshot.GetName())
+}
+
+func waitForVolumeDeleted(namespace, name string) error {
+	err := wait.PollImmediate(volumePollInterval, volumeTimeout, func() (bool, error) {
+		_, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil || !apierrors.IsNotFound(err) {
+			return false, err
+		}
+
+		_, err = client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil || !apierrors.IsNotFound(err) {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out waiting for disk %s and its volume to be deleted", name)
+	}
+
+	return err
+}
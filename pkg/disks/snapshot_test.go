@@ -0,0 +1,56 @@
+package disks
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateSnapshot(t *testing.T) {
+	snapshot := func(ready bool, restoreSize string) *unstructured.Unstructured {
+		status := map[string]interface{}{
+			"readyToUse": ready,
+		}
+		if restoreSize != "" {
+			status["restoreSize"] = restoreSize
+		}
+
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "test-snapshot",
+				},
+				"status": status,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		snapshot *unstructured.Unstructured
+		size     string
+		field    string
+		message  string
+	}{
+		{"ready", snapshot(true, "10Gi"), "10Gi", "", ""},
+		{"larger disk", snapshot(true, "10Gi"), "20Gi", "", ""},
+		{"no restore size", snapshot(true, ""), "1Gi", "", ""},
+		{"not ready", snapshot(false, ""), "10Gi", "source", "is not ready to use"},
+		{"smaller disk", snapshot(true, "10Gi"), "5Gi", "size", "must be at least the size of snapshot test-snapshot (10Gi)"},
+	}
+
+	for _, tt := range tests {
+		errs := validateSnapshot("source", tt.snapshot, "size", tt.size)
+		if tt.field == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: unexpected errs %v", tt.name, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != tt.field || !strings.Contains(errs[0].Message, tt.message) {
+			t.Errorf("%s: errs = %v, want one error on %s containing %q", tt.name, errs, tt.field, tt.message)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package disks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/httprange"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/upload"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const (
+	UploadStateUploading        = "Uploading"
+	UploadStateCompleted        = "Completed"
+	UploadStateChecksumMismatch = "ChecksumMismatch"
+)
+
+type ResponseUpload struct {
+	Disk   string `json:"disk"`
+	State  string `json:"state"`
+	Format string `json:"format"`
+	Offset int64  `json:"offset"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// uploadSession is persisted in annotations on the Disk so that a chunked upload
+// can be resumed through any apiserver replica.
+type uploadSession struct {
+	state  string
+	format string
+	offset int64
+	hash   hash.Hash
+	sum    string
+}
+
+func loadUploadSession(disk *v1alpha1.Disk) (*uploadSession, error) {
+	annotations := disk.ObjectMeta.Annotations
+	session := &uploadSession{
+		state:  annotations[berth.AnnotationUploadState],
+		format: annotations[berth.AnnotationUploadFormat],
+		sum:    annotations[berth.AnnotationUploadSHA256],
+		hash:   sha256.New(),
+	}
+
+	if offset, ok := annotations[berth.AnnotationUploadOffset]; ok {
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		session.offset = n
+	}
+
+	if state, ok := annotations[berth.AnnotationUploadHashState]; ok {
+		data, err := base64.StdEncoding.DecodeString(state)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := session.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+func (s *uploadSession) apply(disk *v1alpha1.Disk) error {
+	state, err := s.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if disk.ObjectMeta.Annotations == nil {
+		disk.ObjectMeta.Annotations = map[string]string{}
+	}
+
+	annotations := disk.ObjectMeta.Annotations
+	annotations[berth.AnnotationUploadState] = s.state
+	annotations[berth.AnnotationUploadFormat] = s.format
+	annotations[berth.AnnotationUploadOffset] = strconv.FormatInt(s.offset, 10)
+
+	if s.sum != "" {
+		annotations[berth.AnnotationUploadSHA256] = s.sum
+		delete(annotations, berth.AnnotationUploadHashState)
+	} else {
+		delete(annotations, berth.AnnotationUploadSHA256)
+		annotations[berth.AnnotationUploadHashState] = base64.StdEncoding.EncodeToString(state)
+	}
+
+	return nil
+}
+
+// continuedBy reports whether a chunk starting at first picks up where the
+// session left off. Chunks must arrive in order and keep the format.
+func (s *uploadSession) continuedBy(first int64, format string) bool {
+	return first == s.offset && (first == 0 || s.format == format)
+}
+
+func (s *uploadSession) response(name string) *ResponseUpload {
+	return &ResponseUpload{
+		Disk:   name,
+		State:  s.state,
+		Format: s.format,
+		Offset: s.offset,
+		SHA256: s.sum,
+	}
+}
+
+// saveUploadSession writes the session to the Disk as it was read when the
+// chunk arrived. A conflict means another chunk or writer got there first, and
+// the client has to ask for the offset again rather than have it overwritten.
+func saveUploadSession(namespace string, disk *v1alpha1.Disk, session *uploadSession) error {
+	if err := session.apply(disk); err != nil {
+		return err
+	}
+
+	_, err := client.Clientset.Disks().Disks(namespace).Update(context.TODO(), disk, metav1.UpdateOptions{})
+	return err
+}
+
+// uploadSize returns the size of the whole image as far as the request tells,
+// or -1 when it does not. A chunk without a total tells how far the image
+// reaches at least.
+func uploadSize(last, total, contentLength int64) int64 {
+	switch {
+	case total >= 0:
+		return total
+	case last >= 0:
+		return last + 1
+	default:
+		return contentLength
+	}
+}
+
+// validateUploadSize refuses images that cannot fit the disk before any of
+// them is written.
+func validateUploadSize(field string, size int64, diskSize string) validation.ErrorList {
+	var errs validation.ErrorList
+	capacity, err := resource.ParseQuantity(diskSize)
+	if err != nil || size < 0 {
+		return errs
+	}
+
+	if size > capacity.Value() {
+		errs = append(errs, validation.NewFieldError(field, fmt.Sprintf("image of %d bytes does not fit the disk size %s", size, diskSize)))
+	}
+
+	return errs
+}
+
+// parseContentRange parses "bytes first-last/total" where total may be "*".
+// A missing header means the body is the whole image.
+func parseContentRange(header string) (first, last, total int64, err error) {
+	if header == "" {
+		return 0, -1, -1, nil
+	}
+
+	return httprange.ParseContentRange(header)
+}
+
+func GetUpload(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	session, err := loadUploadSession(disk)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(session.offset, 10))
+	ctx.JSON(http.StatusOK, session.response(name))
+}
+
+func UploadDisk(ctx *gin.Context) {
+	if upload.DefaultProxy == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": "error: upload proxy is not configured",
+		})
+		return
+	}
+
+	format := ctx.DefaultQuery("format", upload.FormatRaw)
+	var errs validation.ErrorList
+	if format != upload.FormatRaw && format != upload.FormatQCOW2 {
+		errs = append(errs, validation.NewFieldError("format", "must be raw or qcow2"))
+	}
+
+	first, last, total, err := parseContentRange(ctx.GetHeader("Content-Range"))
+	if err != nil {
+		errs = append(errs, validation.NewFieldError("Content-Range", err.Error()))
+	}
+
+	expected := strings.ToLower(ctx.GetHeader("X-Checksum-Sha256"))
+	if expected != "" {
+		if _, err := hex.DecodeString(expected); err != nil || len(expected) != sha256.Size*2 {
+			errs = append(errs, validation.NewFieldError("X-Checksum-Sha256", "must be a hex encoded sha256 digest"))
+		}
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	sizeField := "Content-Length"
+	if last >= 0 {
+		sizeField = "Content-Range"
+	}
+
+	size := uploadSize(last, total, ctx.Request.ContentLength)
+	if errs := validateUploadSize(sizeField, size, disk.Spec.Size); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	running, err := attachedToRunningServer(namespace, disk)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if running {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "disk " + name + " is attached to running server " + disk.Status.AttachedTo,
+		})
+		return
+	}
+
+	session, err := loadUploadSession(disk)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if first == 0 {
+		session = &uploadSession{hash: sha256.New()}
+	}
+
+	if !session.continuedBy(first, format) {
+		ctx.Header("Upload-Offset", strconv.FormatInt(session.offset, 10))
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("upload must resume at offset %d with format %s", session.offset, session.format),
+			"upload":  session.response(name),
+		})
+		return
+	}
+
+	var body io.Reader = ctx.Request.Body
+	if last >= 0 {
+		body = io.LimitReader(body, last-first+1)
+	}
+
+	target := upload.Target{
+		Namespace: namespace,
+		Disk:      name,
+		Format:    format,
+	}
+
+	n, err := upload.DefaultProxy.Write(ctx.Request.Context(), target, first, io.TeeReader(body, session.hash))
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": "upload error: " + err.Error(),
+		})
+		return
+	}
+
+	if last >= 0 && n != last-first+1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("request invalid: received %d bytes, Content-Range announced %d", n, last-first+1),
+		})
+		return
+	}
+
+	session.state = UploadStateUploading
+	session.format = format
+	session.offset = first + n
+
+	complete := last < 0 || session.offset == total
+	status := http.StatusAccepted
+	if complete {
+		sum := hex.EncodeToString(session.hash.Sum(nil))
+		session.sum = sum
+		session.state = UploadStateCompleted
+		status = http.StatusOK
+
+		if expected != "" && expected != sum {
+			session.state = UploadStateChecksumMismatch
+			status = http.StatusUnprocessableEntity
+		} else if err := upload.DefaultProxy.Finalize(ctx.Request.Context(), target, session.offset); err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{
+				"message": "upload error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := saveUploadSession(namespace, disk, session); err != nil {
+		if apierrors.IsConflict(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "disk " + name + " was modified during the upload; get the upload offset and resume from there",
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(session.offset, 10))
+	if status == http.StatusUnprocessableEntity {
+		ctx.JSON(status, gin.H{
+			"message": "checksum mismatch: expected " + expected + ", got " + session.sum,
+			"upload":  session.response(name),
+		})
+		return
+	}
+
+	ctx.JSON(status, session.response(name))
+}
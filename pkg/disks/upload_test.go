@@ -0,0 +1,248 @@
+package disks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/upload"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+	clientset "github.com/kubeberth/kubeberth-operator/pkg/clientset/versioned"
+)
+
+func TestParseContentRangeWholeImage(t *testing.T) {
+	first, last, total, err := parseContentRange("")
+	if err != nil || first != 0 || last != -1 || total != -1 {
+		t.Errorf("parseContentRange(\"\") = %d, %d, %d, %v, want 0, -1, -1, nil", first, last, total, err)
+	}
+
+	for _, header := range []string{"bytes=0-99", "bytes 0-99", "bytes 99-0/100", "bytes 0-99/50"} {
+		if _, _, _, err := parseContentRange(header); err == nil {
+			t.Errorf("parseContentRange(%q) accepted a malformed header", header)
+		}
+	}
+}
+
+func TestUploadSessionContinuedBy(t *testing.T) {
+	session := &uploadSession{format: upload.FormatQCOW2, offset: 100}
+
+	tests := []struct {
+		first  int64
+		format string
+		want   bool
+	}{
+		{first: 100, format: upload.FormatQCOW2, want: true},
+		{first: 50, format: upload.FormatQCOW2, want: false},
+		{first: 150, format: upload.FormatQCOW2, want: false},
+		{first: 100, format: upload.FormatRaw, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := session.continuedBy(tt.first, tt.format); got != tt.want {
+			t.Errorf("continuedBy(%d, %s) = %v, want %v", tt.first, tt.format, got, tt.want)
+		}
+	}
+
+	fresh := &uploadSession{hash: sha256.New()}
+	if !fresh.continuedBy(0, upload.FormatRaw) {
+		t.Errorf("a new session is not continued by a chunk at offset 0")
+	}
+}
+
+// diskAPI stands in for the Kubernetes API serving a single Disk, and like it
+// refuses updates made from a stale resourceVersion.
+type diskAPI struct {
+	mu   sync.Mutex
+	disk v1alpha1.Disk
+
+	// conflict fails the next update as if another writer got there first.
+	conflict bool
+}
+
+func (a *diskAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r.URL.Path != "/apis/berth.kubeberth.io/v1alpha1/namespaces/kubeberth/disks/"+a.disk.ObjectMeta.Name {
+		writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Group: "berth.kubeberth.io", Resource: "disks"}, path.Base(r.URL.Path)))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		disk := v1alpha1.Disk{}
+		if err := json.NewDecoder(r.Body).Decode(&disk); err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+
+		if a.conflict || disk.ObjectMeta.ResourceVersion != a.disk.ObjectMeta.ResourceVersion {
+			a.conflict = false
+			writeStatus(w, apierrors.NewConflict(schema.GroupResource{Group: "berth.kubeberth.io", Resource: "disks"}, disk.ObjectMeta.Name, fmt.Errorf("the object has been modified")))
+			return
+		}
+
+		version, _ := strconv.Atoi(a.disk.ObjectMeta.ResourceVersion)
+		disk.ObjectMeta.ResourceVersion = strconv.Itoa(version + 1)
+		a.disk = disk
+	default:
+		writeStatus(w, apierrors.NewMethodNotSupported(schema.GroupResource{Group: "berth.kubeberth.io", Resource: "disks"}, r.Method))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&a.disk)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	json.NewEncoder(w).Encode(&status)
+}
+
+// TestResumedUpload uploads an image in chunks through UploadDisk against a
+// local stand-in upload server and Kubernetes API, and checks that the
+// session survives between chunks and the digest covers the whole image.
+func TestResumedUpload(t *testing.T) {
+	var mu sync.Mutex
+	received := []byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method != http.MethodPut {
+			return
+		}
+
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if offset == 0 {
+			received = received[:0]
+		}
+
+		if offset != int64(len(received)) {
+			http.Error(w, "unexpected offset", http.StatusConflict)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		received = append(received, data...)
+	}))
+	defer server.Close()
+
+	api := &diskAPI{
+		disk: v1alpha1.Disk{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "kubeberth", ResourceVersion: "1"},
+			Spec:       v1alpha1.DiskSpec{Size: "8Ki"},
+		},
+	}
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+
+	cs, err := clientset.NewForConfig(&rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultProxy, defaultClientset := upload.DefaultProxy, client.Clientset
+	upload.DefaultProxy, client.Clientset = upload.NewHTTPProxy(server.URL, ""), cs
+	defer func() {
+		upload.DefaultProxy, client.Clientset = defaultProxy, defaultClientset
+	}()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/disks/:name/upload", UploadDisk)
+
+	image := bytes.Repeat([]byte("0123456789"), 500)
+	put := func(first, last int, total string) (int, *ResponseUpload) {
+		req := httptest.NewRequest(http.MethodPut, "/disks/test/upload?format=raw", bytes.NewReader(image[first:last]))
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", first, last-1, total))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		ret := &ResponseUpload{}
+		json.Unmarshal(w.Body.Bytes(), ret)
+		return w.Code, ret
+	}
+
+	total := strconv.Itoa(len(image))
+	for _, chunk := range []struct {
+		first, last int
+		status      int
+	}{
+		{0, 1000, http.StatusAccepted},
+		{1000, 3000, http.StatusAccepted},
+		{3000, len(image), http.StatusOK},
+	} {
+		status, ret := put(chunk.first, chunk.last, total)
+		if status != chunk.status || ret.Offset != int64(chunk.last) {
+			t.Fatalf("chunk %d-%d: status %d at offset %d, want %d at %d", chunk.first, chunk.last, status, ret.Offset, chunk.status, chunk.last)
+		}
+	}
+
+	session, err := loadUploadSession(&api.disk)
+	if err != nil {
+		t.Fatalf("loadUploadSession: %v", err)
+	}
+
+	want := sha256.Sum256(image)
+	if session.sum != hex.EncodeToString(want[:]) {
+		t.Errorf("sha256 = %s, want %s", session.sum, hex.EncodeToString(want[:]))
+	}
+
+	if session.state != UploadStateCompleted || session.offset != int64(len(image)) {
+		t.Errorf("session = %s at %d, want %s at %d", session.state, session.offset, UploadStateCompleted, len(image))
+	}
+
+	if !bytes.Equal(received, image) {
+		t.Errorf("the stand-in received a different image")
+	}
+
+	// A chunk replayed after completion is out of order.
+	if status, _ := put(1000, 3000, total); status != http.StatusConflict {
+		t.Errorf("replayed chunk: status %d, want %d", status, http.StatusConflict)
+	}
+
+	// An image larger than the disk is refused before anything is written.
+	version := api.disk.ObjectMeta.ResourceVersion
+	if status, _ := put(0, 1000, "10000"); status != http.StatusUnprocessableEntity {
+		t.Errorf("oversized image: status %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if len(received) != len(image) || api.disk.ObjectMeta.ResourceVersion != version {
+		t.Errorf("oversized image was written")
+	}
+
+	// A session changed by another writer since the chunk read it is not
+	// overwritten.
+	api.conflict = true
+	if status, _ := put(0, 1000, total); status != http.StatusConflict {
+		t.Errorf("concurrent update: status %d, want %d", status, http.StatusConflict)
+	}
+
+	if session, _ := loadUploadSession(&api.disk); session.state != UploadStateCompleted {
+		t.Errorf("concurrent update overwrote the session: %s at %d", session.state, session.offset)
+	}
+}
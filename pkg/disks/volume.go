@@ -0,0 +1,246 @@
+package disks
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+// The operator populates each disk through a CDI DataVolume of the same name,
+// which reports the import or clone progress.
+var dataVolumeResource = schema.GroupVersionResource{
+	Group:    "cdi.kubevirt.io",
+	Version:  "v1beta1",
+	Resource: "datavolumes",
+}
+
+// A PVC that carries this annotation with the name of a DataVolume is adopted
+// by CDI as that DataVolume's volume instead of being populated again.
+const annotationPrePopulated = "cdi.kubevirt.io/storage.prePopulated"
+
+// volumeStatus is what the backing PVC and DataVolume report about a disk.
+type volumeStatus struct {
+	capacity     string
+	storageClass string
+	volumeMode   string
+	accessMode   string
+	progress     string
+}
+
+func (r *ResponseDisk) setVolumeStatus(v *volumeStatus) {
+	if v == nil {
+		return
+	}
+
+	r.Capacity = v.capacity
+	r.Progress = v.progress
+
+	r.StorageClass = v.storageClass
+	r.VolumeMode = v.volumeMode
+	r.AccessMode = v.accessMode
+}
+
+func convertPVC2VolumeStatus(pvc *corev1.PersistentVolumeClaim) *volumeStatus {
+	ret := &volumeStatus{}
+
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		ret.capacity = capacity.String()
+	}
+
+	if pvc.Spec.StorageClassName != nil {
+		ret.storageClass = *pvc.Spec.StorageClassName
+	}
+
+	if pvc.Spec.VolumeMode != nil {
+		ret.volumeMode = string(*pvc.Spec.VolumeMode)
+	}
+
+	if len(pvc.Spec.AccessModes) > 0 {
+		ret.accessMode = string(pvc.Spec.AccessModes[0])
+	}
+
+	return ret
+}
+
+// diskVolumeClaim returns the PVC backing a disk. CDI names it after the
+// DataVolume and owns it, and a volume restored from a snapshot is owned by
+// the disk itself; a PVC of the same name owned by neither is not the disk's
+// volume.
+func diskVolumeClaim(namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	pvc, err := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if ownsVolume(pvc, name) {
+		return pvc, nil
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("persistentvolumeclaims"), name)
+}
+
+// ownsVolume reports whether the PVC is the volume of the named disk.
+func ownsVolume(pvc *corev1.PersistentVolumeClaim, name string) bool {
+	for _, owner := range pvc.ObjectMeta.OwnerReferences {
+		if (owner.Kind == "DataVolume" || owner.Kind == "Disk") && owner.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createDiskFromSnapshot creates the disk's volume from the snapshot before
+// the disk itself, so that the DataVolume the operator creates for the disk
+// adopts the volume rather than populating a new one.
+func createDiskFromSnapshot(namespace string, disk *v1alpha1.Disk, snapshot *unstructured.Unstructured) (*v1alpha1.Disk, error) {
+	name := disk.ObjectMeta.Name
+	size, err := resource.ParseQuantity(disk.Spec.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	group := volumeSnapshotResource.Group
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				annotationPrePopulated: name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &group,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshot.GetName(),
+			},
+		},
+	}
+
+	// Snapshots taken through this API remember the settings of their volume.
+	annotations := snapshot.GetAnnotations()
+	if storageClass := annotations[berth.AnnotationStorageClass]; storageClass != "" {
+		pvc.Spec.StorageClassName = &storageClass
+	}
+
+	if volumeMode := annotations[berth.AnnotationVolumeMode]; volumeMode != "" {
+		mode := corev1.PersistentVolumeMode(volumeMode)
+		pvc.Spec.VolumeMode = &mode
+	}
+
+	if accessMode := annotations[berth.AnnotationAccessMode]; accessMode != "" {
+		pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.PersistentVolumeAccessMode(accessMode)}
+	}
+
+	if disk.ObjectMeta.Annotations == nil {
+		disk.ObjectMeta.Annotations = map[string]string{}
+	}
+	disk.ObjectMeta.Annotations[berth.AnnotationSourceSnapshot] = snapshot.GetName()
+
+	if _, err := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Create(context.TODO(), pvc, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+
+	ret, err := client.Clientset.Disks().Disks(namespace).Create(context.TODO(), disk, metav1.CreateOptions{})
+	if err != nil {
+		if e := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}); e != nil {
+			klog.Errorf("disk %s: deleting volume after failed create: %s", name, e.Error())
+		}
+		return nil, err
+	}
+
+	// The disk owns the volume so that deleting the disk deletes it.
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pvc, err := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		pvc.ObjectMeta.OwnerReferences = append(pvc.ObjectMeta.OwnerReferences, metav1.OwnerReference{
+			APIVersion: berth.APIVersion,
+			Kind:       "Disk",
+			Name:       name,
+			UID:        ret.ObjectMeta.UID,
+		})
+
+		_, err = client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Update(context.TODO(), pvc, metav1.UpdateOptions{})
+		return err
+	})
+
+	return ret, err
+}
+
+func getVolumeStatus(namespace, name string) (*volumeStatus, error) {
+	ret := &volumeStatus{}
+
+	pvc, err := diskVolumeClaim(namespace, name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		ret = convertPVC2VolumeStatus(pvc)
+	}
+
+	dv, err := client.Dynamic.Resource(dataVolumeResource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		ret.progress, _, _ = unstructured.NestedString(dv.Object, "status", "progress")
+	}
+
+	return ret, nil
+}
+
+// listVolumeStatuses fetches the volumes of every disk in the namespace at once,
+// keyed by disk name.
+func listVolumeStatuses(namespace string) (map[string]*volumeStatus, error) {
+	ret := map[string]*volumeStatus{}
+
+	pvcs, err := client.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if ownsVolume(pvc, pvc.ObjectMeta.Name) {
+			ret[pvc.ObjectMeta.Name] = convertPVC2VolumeStatus(pvc)
+		}
+	}
+
+	dvs, err := client.Dynamic.Resource(dataVolumeResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, dv := range dvs.Items {
+			v, ok := ret[dv.GetName()]
+			if !ok {
+				v = &volumeStatus{}
+				ret[dv.GetName()] = v
+			}
+			v.progress, _, _ = unstructured.NestedString(dv.Object, "status", "progress")
+		}
+	}
+
+	return ret, nil
+}
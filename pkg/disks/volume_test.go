@@ -0,0 +1,34 @@
+package disks
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOwnsVolume(t *testing.T) {
+	pvc := func(owners ...metav1.OwnerReference) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", OwnerReferences: owners},
+		}
+	}
+
+	tests := []struct {
+		name string
+		pvc  *corev1.PersistentVolumeClaim
+		want bool
+	}{
+		{"no owner", pvc(), false},
+		{"datavolume", pvc(metav1.OwnerReference{Kind: "DataVolume", Name: "test"}), true},
+		{"restored disk", pvc(metav1.OwnerReference{Kind: "Disk", Name: "test"}), true},
+		{"other datavolume", pvc(metav1.OwnerReference{Kind: "DataVolume", Name: "other"}), false},
+		{"other kind", pvc(metav1.OwnerReference{Kind: "StatefulSet", Name: "test"}), false},
+	}
+
+	for _, tt := range tests {
+		if got := ownsVolume(tt.pvc, "test"); got != tt.want {
+			t.Errorf("ownsVolume(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
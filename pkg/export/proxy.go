@@ -0,0 +1,124 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/httprange"
+)
+
+const (
+	FormatRaw   = "raw"
+	FormatQCOW2 = "qcow2"
+)
+
+type Target struct {
+	Namespace string
+	Disk      string
+	Format    string
+}
+
+// Stream is a window of the exported image starting at Offset.
+// Size is the size of the whole image, or -1 when the proxy does not know it.
+type Stream struct {
+	Body   io.ReadCloser
+	Offset int64
+	Length int64
+	Size   int64
+}
+
+// Proxy reads the volume backing a disk. A negative length reads to the end.
+type Proxy interface {
+	Open(ctx context.Context, target Target, offset, length int64) (*Stream, error)
+}
+
+var (
+	DefaultProxy Proxy
+)
+
+// HTTPProxy reads from an export server that serves
+//
+//	GET {endpoint}/{namespace}/{disk}?format=...
+//
+// and honours single byte-range requests.
+type HTTPProxy struct {
+	Endpoint string
+	Token    string
+	Client   *http.Client
+}
+
+func NewHTTPProxy(endpoint, token string) *HTTPProxy {
+	return &HTTPProxy{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Token:    token,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (p *HTTPProxy) Open(ctx context.Context, target Target, offset, length int64) (*Stream, error) {
+	query := url.Values{
+		"format": {target.Format},
+	}
+	u := p.Endpoint + "/" + url.PathEscape(target.Namespace) + "/" + url.PathEscape(target.Disk) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	partial := offset > 0 || length >= 0
+	if partial {
+		r := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			r += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", r)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if partial {
+			resp.Body.Close()
+			return nil, fmt.Errorf("export proxy: range requests are not supported")
+		}
+
+		return &Stream{
+			Body:   resp.Body,
+			Offset: 0,
+			Length: resp.ContentLength,
+			Size:   resp.ContentLength,
+		}, nil
+	case http.StatusPartialContent:
+		first, _, size, err := httprange.ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		return &Stream{
+			Body:   resp.Body,
+			Offset: first,
+			Length: resp.ContentLength,
+			Size:   size,
+		}, nil
+	default:
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("export proxy: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
@@ -0,0 +1,75 @@
+package httprange
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseContentRange parses "bytes first-last/size" where size may be "*" (-1).
+func ParseContentRange(header string) (first, last, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range: %s", header)
+
+	spec := strings.TrimPrefix(header, "bytes ")
+	if spec == header {
+		return 0, 0, 0, invalid
+	}
+
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, 0, invalid
+	}
+
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, 0, invalid
+	}
+
+	if first, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+
+	if last, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || last < first {
+		return 0, 0, 0, invalid
+	}
+
+	size = -1
+	if parts[1] != "*" {
+		if size, err = strconv.ParseInt(parts[1], 10, 64); err != nil || size <= last {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	return first, last, size, nil
+}
+
+// ParseRange parses a single "bytes=first-[last]" Range header. Suffix ranges
+// and multiple ranges are not supported. A missing last byte returns length -1.
+func ParseRange(header string) (offset, length int64, err error) {
+	invalid := fmt.Errorf("unsupported Range: %s", header)
+
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, invalid
+	}
+
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 || bounds[0] == "" {
+		return 0, 0, invalid
+	}
+
+	if offset, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, invalid
+	}
+
+	if bounds[1] == "" {
+		return offset, -1, nil
+	}
+
+	last, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || last < offset {
+		return 0, 0, invalid
+	}
+
+	return offset, last - offset + 1, nil
+}
@@ -0,0 +1,86 @@
+package httprange
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header            string
+		first, last, size int64
+		wantErr           bool
+	}{
+		{header: "bytes 0-99/200", first: 0, last: 99, size: 200},
+		{header: "bytes 100-199/200", first: 100, last: 199, size: 200},
+		{header: "bytes 0-0/1", first: 0, last: 0, size: 1},
+		{header: "bytes 0-99/*", first: 0, last: 99, size: -1},
+		{header: "", wantErr: true},
+		{header: "0-99/200", wantErr: true},
+		{header: "items 0-99/200", wantErr: true},
+		{header: "bytes 0-99", wantErr: true},
+		{header: "bytes 99/200", wantErr: true},
+		{header: "bytes -99/200", wantErr: true},
+		{header: "bytes 0-/200", wantErr: true},
+		{header: "bytes a-99/200", wantErr: true},
+		{header: "bytes 100-99/200", wantErr: true},
+		{header: "bytes 0-199/200x", wantErr: true},
+		{header: "bytes 0-199/199", wantErr: true},
+		{header: "bytes 0-199/200/300", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		first, last, size, err := ParseContentRange(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseContentRange(%q) = %d, %d, %d, want an error", tt.header, first, last, size)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseContentRange(%q) error: %v", tt.header, err)
+			continue
+		}
+
+		if first != tt.first || last != tt.last || size != tt.size {
+			t.Errorf("ParseContentRange(%q) = %d, %d, %d, want %d, %d, %d", tt.header, first, last, size, tt.first, tt.last, tt.size)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header         string
+		offset, length int64
+		wantErr        bool
+	}{
+		{header: "bytes=0-99", offset: 0, length: 100},
+		{header: "bytes=100-", offset: 100, length: -1},
+		{header: "bytes=5-5", offset: 5, length: 1},
+		{header: "", wantErr: true},
+		{header: "bytes 0-99", wantErr: true},
+		{header: "bytes=-100", wantErr: true},
+		{header: "bytes=0-99,200-299", wantErr: true},
+		{header: "bytes=99-0", wantErr: true},
+		{header: "bytes=a-99", wantErr: true},
+		{header: "bytes=0-b", wantErr: true},
+		{header: "bytes=100", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		offset, length, err := ParseRange(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRange(%q) = %d, %d, want an error", tt.header, offset, length)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseRange(%q) error: %v", tt.header, err)
+			continue
+		}
+
+		if offset != tt.offset || length != tt.length {
+			t.Errorf("ParseRange(%q) = %d, %d, want %d, %d", tt.header, offset, length, tt.offset, tt.length)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package instancetypes
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+type InstanceType struct {
+	Name   string             `json:"name"`
+	CPU    *resource.Quantity `json:"cpu"`
+	Memory *resource.Quantity `json:"memory"`
+}
+
+var (
+	catalog = defaultInstanceTypes()
+)
+
+func defaultInstanceTypes() []InstanceType {
+	newInstanceType := func(name, cpu, memory string) InstanceType {
+		c := resource.MustParse(cpu)
+		m := resource.MustParse(memory)
+		return InstanceType{Name: name, CPU: &c, Memory: &m}
+	}
+
+	return []InstanceType{
+		newInstanceType("small", "1", "1Gi"),
+		newInstanceType("medium", "2", "2Gi"),
+		newInstanceType("large", "4", "8Gi"),
+	}
+}
+
+// Load replaces the catalog with the instance types defined in path.
+// A missing file keeps the built-in small/medium/large catalog.
+func Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var types []InstanceType
+	if err := yaml.Unmarshal(data, &types); err != nil {
+		return err
+	}
+
+	for i, t := range types {
+		field := validation.Index("instancetypes", i)
+		errs := validation.ValidateName(validation.Child(field, "name"), t.Name)
+		errs = append(errs, validation.ValidateQuantity(validation.Child(field, "cpu"), t.CPU)...)
+		errs = append(errs, validation.ValidateQuantity(validation.Child(field, "memory"), t.Memory)...)
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	catalog = types
+	return nil
+}
+
+func Get(name string) (*InstanceType, bool) {
+	for i := range catalog {
+		if catalog[i].Name == name {
+			return &catalog[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func Match(cpu, memory *resource.Quantity) string {
+	if cpu == nil || memory == nil {
+		return ""
+	}
+
+	for _, t := range catalog {
+		if t.CPU.Cmp(*cpu) == 0 && t.Memory.Cmp(*memory) == 0 {
+			return t.Name
+		}
+	}
+
+	return ""
+}
+
+// Resolve returns the cpu and memory for a request that may name an instance type
+// instead of (or in addition to) explicit quantities.
+func Resolve(field, name string, cpu, memory *resource.Quantity) (*resource.Quantity, *resource.Quantity, validation.ErrorList) {
+	var errs validation.ErrorList
+	if name == "" {
+		return cpu, memory, errs
+	}
+
+	t, ok := Get(name)
+	if !ok {
+		return cpu, memory, append(errs, validation.NewFieldError(field, "unknown instance type "+name))
+	}
+
+	if cpu != nil && cpu.Cmp(*t.CPU) != 0 {
+		errs = append(errs, validation.NewFieldError("cpu", "does not match instance type "+name+" ("+t.CPU.String()+")"))
+	}
+
+	if memory != nil && memory.Cmp(*t.Memory) != 0 {
+		errs = append(errs, validation.NewFieldError("memory", "does not match instance type "+name+" ("+t.Memory.String()+")"))
+	}
+
+	c := t.CPU.DeepCopy()
+	m := t.Memory.DeepCopy()
+	return &c, &m, errs
+}
+
+func GetAllInstanceTypes(ctx *gin.Context) {
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	ret, meta, errs := lists.Page(append([]InstanceType{}, catalog...), opts)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(ret, meta))
+}
+
+func GetInstanceType(ctx *gin.Context) {
+	name := ctx.Param("name")
+	t, ok := Get(name)
+
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "error: instance type " + name + " not found",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, t)
+}
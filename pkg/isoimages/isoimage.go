@@ -6,22 +6,31 @@ import (
 
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
 type ResponseISOImage struct {
-	Name       string `json:"name"`
-	State      string `json:"state"`
-	Size       string `json:"size"`
-	Repository string `json:"repository"`
+	Name         string                 `json:"name"`
+	State        string                 `json:"state"`
+	Size         string                 `json:"size"`
+	Repository   string                 `json:"repository"`
+	Checksum     *checksum.Spec         `json:"checksum,omitempty"`
+	Verification *checksum.Status       `json:"verification,omitempty"`
+	UsedBy       []references.Reference `json:"used_by"`
 }
 
 type RequestISOImage struct {
-	Name       string `json:"name"       binding:"required"`
-	Size       string `json:"size"       binding:"required"`
-	Repository string `json:"repository" binding:"required"`
+	Name       string         `json:"name"       binding:"required"`
+	Size       string         `json:"size"       binding:"required"`
+	Repository string         `json:"repository" binding:"required"`
+	Checksum   *checksum.Spec `json:"checksum"`
 }
 
 func convertISOImage2ISOImage(isoimage v1alpha1.ISOImage) *ResponseISOImage {
@@ -30,28 +39,62 @@ func convertISOImage2ISOImage(isoimage v1alpha1.ISOImage) *ResponseISOImage {
 		State:      isoimage.Status.State,
 		Size:       isoimage.Spec.Size,
 		Repository: isoimage.Spec.Repository,
+		UsedBy:     []references.Reference{},
 	}
 
+	ret.Checksum, ret.Verification = checksum.FromAnnotations(isoimage.ObjectMeta.Annotations)
+
 	return ret
 }
 
+func validateRequestISOImage(iso *RequestISOImage) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", iso.Name)...)
+	errs = append(errs, validation.ValidateQuantityString("size", iso.Size)...)
+	errs = append(errs, validation.ValidateRepository("repository", iso.Repository)...)
+	errs = append(errs, checksum.Validate("checksum", iso.Checksum)...)
+
+	return errs
+}
+
 func GetAllISOImages(ctx *gin.Context) {
 	namespace := "kubeberth"
-	isoimages, err := client.Clientset.ISOImages().ISOImages(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	isoimages, err := client.Clientset.ISOImages().ISOImages(namespace).List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
 
-	if err != nil || len(isoimages.Items) == 0 {
+	usage, err := references.ISOImageUsage(namespace)
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
-	var ret []*ResponseISOImage
+	ret := []*ResponseISOImage{}
 	for _, isoimage := range isoimages.Items {
-		ret = append(ret, convertISOImage2ISOImage(isoimage))
+		iso := convertISOImage2ISOImage(isoimage)
+		if refs, ok := usage[isoimage.ObjectMeta.Name]; ok {
+			iso.UsedBy = refs
+		}
+		ret = append(ret, iso)
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, isoimages.ListMeta))
 }
 
 func GetISOImage(ctx *gin.Context) {
@@ -66,35 +109,101 @@ func GetISOImage(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertISOImage2ISOImage(*isoimage))
+	refs, err := references.ServersReferencingISOImage(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := convertISOImage2ISOImage(*isoimage)
+	ret.UsedBy = refs
+
+	ctx.JSON(http.StatusOK, ret)
 }
 
-func CreateISOImage(ctx *gin.Context) {
-	var iso RequestISOImage
-	if err := ctx.ShouldBindJSON(&iso); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "request invalid: " + err.Error(),
+func GetISOImageUsage(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+
+	if _, err := client.Clientset.ISOImages().ISOImages(namespace).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
-	name := iso.Name
-	namespace := "kubeberth"
-	size := iso.Size
-	repository := iso.Repository
+	refs, err := references.ServersReferencingISOImage(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
 
+	ctx.JSON(http.StatusOK, &references.Usage{
+		Name:   name,
+		UsedBy: refs,
+	})
+}
+
+// Create stores a validated ISO image and starts verifying its checksum, if one
+// was given.
+func Create(namespace string, iso *RequestISOImage) (*ResponseISOImage, error) {
 	isoimage := &v1alpha1.ISOImage{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
+			Name:      iso.Name,
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.ISOImageSpec{
-			Size:       size,
-			Repository: repository,
+			Size:       iso.Size,
+			Repository: iso.Repository,
 		},
 	}
 
+	pending := &checksum.Status{State: checksum.StatePending}
+	if err := checksum.SetAnnotations(&isoimage.ObjectMeta, iso.Checksum, pending); err != nil {
+		return nil, err
+	}
+
 	ret, err := client.Clientset.ISOImages().ISOImages(namespace).Create(context.TODO(), isoimage, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// An ISO image whose verification could not even be recorded is not left
+	// behind for a request that reports failure.
+	response := convertISOImage2ISOImage(*ret)
+	if err := startVerification(namespace, ret, response); err != nil {
+		if err := client.Clientset.ISOImages().ISOImages(namespace).Delete(context.TODO(), ret.ObjectMeta.Name, metav1.DeleteOptions{}); err != nil {
+			klog.Errorf("isoimage %s: rolling back: %s", ret.ObjectMeta.Name, err.Error())
+		}
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func CreateISOImage(ctx *gin.Context) {
+	var iso RequestISOImage
+	if err := ctx.ShouldBindJSON(&iso); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validateRequestISOImage(&iso); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	namespace := "kubeberth"
+	ret, err := Create(namespace, &iso)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
@@ -102,7 +211,7 @@ func CreateISOImage(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertISOImage2ISOImage(*ret))
+	ctx.JSON(http.StatusOK, ret)
 }
 
 func UpdateISOImage(ctx *gin.Context) {
@@ -114,6 +223,14 @@ func UpdateISOImage(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateRequestISOImage(&iso); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := iso.Name
 	namespace := "kubeberth"
 	size := iso.Size
@@ -127,13 +244,26 @@ func UpdateISOImage(ctx *gin.Context) {
 		return
 	}
 
+	previous, _ := checksum.FromAnnotations(isoimage.ObjectMeta.Annotations)
+	verify := iso.Checksum != nil && (repository != isoimage.Spec.Repository || !checksum.Equal(previous, iso.Checksum))
+
 	spec := v1alpha1.ISOImageSpec{
-		Size: size,
+		Size:       size,
 		Repository: repository,
 	}
 
 	isoimage.Spec = spec
 
+	if iso.Checksum == nil || verify {
+		pending := &checksum.Status{State: checksum.StatePending}
+		if err := checksum.SetAnnotations(&isoimage.ObjectMeta, iso.Checksum, pending); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "update error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	ret, err := client.Clientset.ISOImages().ISOImages(namespace).Update(context.TODO(), isoimage, metav1.UpdateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -142,12 +272,49 @@ func UpdateISOImage(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, convertISOImage2ISOImage(*ret))
+	response := convertISOImage2ISOImage(*ret)
+	response.UsedBy, err = references.ServersReferencingISOImage(namespace, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if verify {
+		if err := startVerification(namespace, ret, response); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "verify error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
 
 func DeleteISOImage(ctx *gin.Context) {
 	name := ctx.Param("name")
 	namespace := "kubeberth"
+
+	if ctx.Query("force") != "true" {
+		refs, err := references.ServersReferencingISOImage(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if len(refs) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":       "isoimage " + name + " is still referenced",
+				"referenced_by": refs,
+			})
+			return
+		}
+	}
+
 	err := client.Clientset.ISOImages().ISOImages(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 
 	if err != nil {
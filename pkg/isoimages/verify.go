@@ -0,0 +1,150 @@
+package isoimages
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/checksum"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+func isoImageOwnerReference(isoimage *v1alpha1.ISOImage) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
+		APIVersion: berth.APIVersion,
+		Kind:       "ISOImage",
+		Name:       isoimage.ObjectMeta.Name,
+		UID:        isoimage.ObjectMeta.UID,
+	}
+}
+
+func saveVerification(namespace, name string) func(*checksum.Status) error {
+	return func(status *checksum.Status) error {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			isoimage, err := client.Clientset.ISOImages().ISOImages(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			changed, err := checksum.Apply(&isoimage.ObjectMeta, status)
+			if err != nil || !changed {
+				return err
+			}
+
+			_, err = client.Clientset.ISOImages().ISOImages(namespace).Update(context.TODO(), isoimage, metav1.UpdateOptions{})
+			return err
+		})
+	}
+}
+
+// startVerification hashes the ISO image in the background if it has a
+// checksum, and reports the initial status in ret.
+func startVerification(namespace string, isoimage *v1alpha1.ISOImage, ret *ResponseISOImage) error {
+	if ret.Checksum == nil {
+		return nil
+	}
+
+	name := isoimage.ObjectMeta.Name
+	_, status, err := checksum.Start(namespace, "isoimages/"+name, isoimage.Spec.Repository, ret.Checksum, isoImageOwnerReference(isoimage), saveVerification(namespace, name))
+	if err != nil {
+		// The ISO image is stored already, so the failure is recorded on it
+		// rather than failing the request.
+		status = &checksum.Status{
+			State:   checksum.StateFailed,
+			Message: "starting verification: " + err.Error(),
+		}
+
+		if err := saveVerification(namespace, name)(status); err != nil {
+			return err
+		}
+	}
+
+	ret.Verification = status
+	return nil
+}
+
+// ResumeVerifications starts again the verifications that were lost with the
+// apiserver replica running them, or that never got started.
+func ResumeVerifications() {
+	namespace := "kubeberth"
+	isoimages, err := client.Clientset.ISOImages().ISOImages(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("resuming verifications: %s", err.Error())
+		return
+	}
+
+	for i := range isoimages.Items {
+		isoimage := &isoimages.Items[i]
+		ret := convertISOImage2ISOImage(*isoimage)
+		stalled, err := checksum.Stalled(namespace, ret.Verification)
+		if err != nil {
+			klog.Errorf("ISO image %s: checking verification: %s", isoimage.ObjectMeta.Name, err.Error())
+			continue
+		}
+
+		if !stalled {
+			continue
+		}
+
+		klog.Infof("ISO image %s: resuming verification", isoimage.ObjectMeta.Name)
+		if err := startVerification(namespace, isoimage, ret); err != nil {
+			klog.Errorf("ISO image %s: resuming verification: %s", isoimage.ObjectMeta.Name, err.Error())
+		}
+	}
+}
+
+// VerifyISOImage verifies the image again against the checksum it already has,
+// for instance after the mirror was fixed.
+func VerifyISOImage(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	isoimage, err := client.Clientset.ISOImages().ISOImages(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := convertISOImage2ISOImage(*isoimage)
+	if ret.Checksum == nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  validation.ErrorList{validation.NewFieldError("checksum", "ISO image "+name+" has no checksum to verify")},
+		})
+		return
+	}
+
+	stalled, err := checksum.Stalled(namespace, ret.Verification)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if ret.Verification != nil && ret.Verification.State == checksum.StateVerifying && !stalled {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message":      "ISO image " + name + " is being verified already",
+			"verification": ret.Verification,
+		})
+		return
+	}
+
+	if err := startVerification(namespace, isoimage, ret); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, ret)
+}
@@ -0,0 +1,316 @@
+package keypairs
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+const (
+	labelKeyPair   = "kubeberth.io/keypair"
+	keyPublicKey   = "public_key"
+	keyFingerprint = "fingerprint"
+)
+
+// Key pairs only hold public keys, so they are kept in ConfigMaps. A generated
+// private key is returned once and never stored.
+type KeyPair struct {
+	Name        string      `json:"name"          binding:"required"`
+	PublicKey   string      `json:"public_key"`
+	Fingerprint string      `json:"fingerprint"`
+	Type        string      `json:"type"`
+	PrivateKey  string      `json:"private_key,omitempty"`
+	CreatedAt   metav1.Time `json:"created_at"`
+}
+
+func configMapName(name string) string {
+	return "keypair-" + name
+}
+
+func convertConfigMap2KeyPair(configmap corev1.ConfigMap) *KeyPair {
+	ret := &KeyPair{
+		Name:        configmap.ObjectMeta.Labels[labelKeyPair],
+		PublicKey:   configmap.Data[keyPublicKey],
+		Fingerprint: configmap.Data[keyFingerprint],
+		CreatedAt:   configmap.ObjectMeta.CreationTimestamp,
+	}
+
+	if fields := strings.Fields(ret.PublicKey); len(fields) > 0 {
+		ret.Type = fields[0]
+	}
+
+	return ret
+}
+
+func validateKeyPair(k *KeyPair) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", k.Name)...)
+
+	if k.PublicKey != "" {
+		if _, err := parsePublicKey(k.PublicKey); err != nil {
+			errs = append(errs, validation.NewFieldError("public_key", err.Error()))
+		}
+	}
+
+	if k.PrivateKey != "" {
+		errs = append(errs, validation.NewFieldError("private_key", "must not be given; leave public_key empty to generate a key pair"))
+	}
+
+	return errs
+}
+
+// parsePublicKey accepts a single key in authorized_keys format.
+func parsePublicKey(publicKey string) (ssh.PublicKey, error) {
+	key, _, options, rest, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("must be a public key in authorized_keys format")
+	}
+
+	if len(options) > 0 {
+		return nil, fmt.Errorf("must not have options")
+	}
+
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, fmt.Errorf("must be a single key")
+	}
+
+	return key, nil
+}
+
+func getKeyPairConfigMap(namespace, name string) (*corev1.ConfigMap, int, error) {
+	configmap, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configMapName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, http.StatusNotFound, fmt.Errorf("keypair %s not found", name)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if configmap.ObjectMeta.Labels[labelKeyPair] != name {
+		return nil, http.StatusNotFound, fmt.Errorf("keypair %s not found", name)
+	}
+
+	return configmap, http.StatusOK, nil
+}
+
+// PublicKeys returns the public keys of the named key pairs, in order.
+func PublicKeys(namespace, field string, names []string) ([]string, validation.ErrorList, error) {
+	var errs validation.ErrorList
+	keys := []string{}
+	for i, name := range names {
+		configmap, status, err := getKeyPairConfigMap(namespace, name)
+		if status == http.StatusNotFound {
+			errs = append(errs, validation.NewFieldError(validation.Index(field, i), "keypair "+name+" does not exist"))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, strings.TrimSpace(configmap.Data[keyPublicKey]))
+	}
+
+	return keys, errs, nil
+}
+
+func GetAllKeyPairs(ctx *gin.Context) {
+	namespace := "kubeberth"
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	opts.LabelSelector = labelKeyPair
+	configmaps, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := []*KeyPair{}
+	for _, configmap := range configmaps.Items {
+		ret = append(ret, convertConfigMap2KeyPair(configmap))
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(ret, configmaps.ListMeta))
+}
+
+func GetKeyPair(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	configmap, status, err := getKeyPairConfigMap(namespace, name)
+
+	if err != nil {
+		ctx.JSON(status, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertConfigMap2KeyPair(*configmap))
+}
+
+func CreateKeyPair(ctx *gin.Context) {
+	var k KeyPair
+	if err := ctx.ShouldBindJSON(&k); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validateKeyPair(&k); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := k.Name
+	namespace := "kubeberth"
+
+	var publicKey ssh.PublicKey
+	privateKey := ""
+	if k.PublicKey != "" {
+		publicKey, _ = parsePublicKey(k.PublicKey)
+	} else {
+		var err error
+		publicKey, privateKey, err = generate(name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// The comment of an uploaded key is kept, a generated key is named after
+	// the key pair.
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+	if k.PublicKey != "" {
+		if fields := strings.Fields(k.PublicKey); len(fields) > 2 {
+			authorizedKey += " " + strings.Join(fields[2:], " ")
+		}
+	} else {
+		authorizedKey += " " + name
+	}
+
+	configmap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(name),
+			Namespace: namespace,
+			Labels: map[string]string{
+				labelKeyPair: name,
+			},
+		},
+		Data: map[string]string{
+			keyPublicKey:   authorizedKey,
+			keyFingerprint: ssh.FingerprintSHA256(publicKey),
+		},
+	}
+
+	ret, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Create(context.TODO(), configmap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "keypair " + name + " already exists",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	response := convertConfigMap2KeyPair(*ret)
+	response.PrivateKey = privateKey
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func DeleteKeyPair(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	if _, status, err := getKeyPairConfigMap(namespace, name); err != nil {
+		ctx.JSON(status, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if ctx.Query("force") != "true" {
+		refs, err := references.ServersReferencingKeyPair(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if len(refs) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message":       "keypair " + name + " is still referenced",
+				"referenced_by": refs,
+			})
+			return
+		}
+	}
+
+	err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), configMapName(name), metav1.DeleteOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "ok",
+	})
+}
+
+// generate creates an ed25519 key pair and returns the private key in the
+// OpenSSH format ssh-keygen writes.
+func generate(comment string) (ssh.PublicKey, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	publicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	privateKey, err := marshalOpenSSHPrivateKey(publicKey, priv, comment)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return publicKey, privateKey, nil
+}
@@ -0,0 +1,64 @@
+package keypairs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const opensshMagic = "openssh-key-v1\x00"
+
+// marshalOpenSSHPrivateKey encodes an unencrypted ed25519 key as described in
+// OpenSSH's PROTOCOL.key.
+func marshalOpenSSHPrivateKey(publicKey ssh.PublicKey, priv ed25519.PrivateKey, comment string) (string, error) {
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return "", err
+	}
+
+	private := struct {
+		Check1  uint32
+		Check2  uint32
+		KeyType string
+		Public  []byte
+		Private []byte
+		Comment string
+	}{
+		Check1:  binary.BigEndian.Uint32(check),
+		Check2:  binary.BigEndian.Uint32(check),
+		KeyType: ssh.KeyAlgoED25519,
+		Public:  priv.Public().(ed25519.PublicKey),
+		Private: priv,
+		Comment: comment,
+	}
+
+	block := ssh.Marshal(private)
+	for i := 1; len(block)%8 != 0; i++ {
+		block = append(block, byte(i))
+	}
+
+	key := struct {
+		CipherName   string
+		KDFName      string
+		KDFOptions   string
+		NumKeys      uint32
+		PublicKey    []byte
+		PrivateBlock []byte
+	}{
+		CipherName:   "none",
+		KDFName:      "none",
+		NumKeys:      1,
+		PublicKey:    publicKey.Marshal(),
+		PrivateBlock: block,
+	}
+
+	data := append([]byte(opensshMagic), ssh.Marshal(key)...)
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: data,
+	})), nil
+}
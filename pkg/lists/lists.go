@@ -0,0 +1,76 @@
+package lists
+
+import (
+	"reflect"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+// Response is the envelope every list endpoint returns. Items is always an
+// array, never null, and Continue is set when ?limit cut the list short.
+type Response struct {
+	Items           interface{} `json:"items"`
+	Count           int         `json:"count"`
+	Continue        string      `json:"continue"`
+	ResourceVersion string      `json:"resourceVersion"`
+}
+
+// New wraps items, which must be a slice, with the list metadata of the
+// Kubernetes list it was built from.
+func New(items interface{}, meta metav1.ListMeta) *Response {
+	return &Response{
+		Items:           items,
+		Count:           reflect.ValueOf(items).Len(),
+		Continue:        meta.Continue,
+		ResourceVersion: meta.ResourceVersion,
+	}
+}
+
+// Options reads the ?limit and ?continue pagination parameters.
+func Options(ctx *gin.Context) (metav1.ListOptions, validation.ErrorList) {
+	var errs validation.ErrorList
+	opts := metav1.ListOptions{
+		Continue: ctx.Query("continue"),
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n <= 0 {
+			errs = append(errs, validation.NewFieldError("limit", "must be a positive integer"))
+		}
+		opts.Limit = n
+	}
+
+	return opts, errs
+}
+
+// Page applies opts to items, a slice held in memory rather than listed from
+// the API server. The continue token is the offset of the next item.
+func Page(items interface{}, opts metav1.ListOptions) (interface{}, metav1.ListMeta, validation.ErrorList) {
+	var errs validation.ErrorList
+	var meta metav1.ListMeta
+	v := reflect.ValueOf(items)
+
+	first := 0
+	if opts.Continue != "" {
+		n, err := strconv.Atoi(opts.Continue)
+		if err != nil || n < 0 || n > v.Len() {
+			errs = append(errs, validation.NewFieldError("continue", "is not a valid continue token"))
+			return items, meta, errs
+		}
+		first = n
+	}
+
+	last := v.Len()
+	if opts.Limit > 0 && int64(last-first) > opts.Limit {
+		last = first + int(opts.Limit)
+		meta.Continue = strconv.Itoa(last)
+	}
+
+	return v.Slice(first, last).Interface(), meta, errs
+}
@@ -0,0 +1,134 @@
+package lists
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query   string
+		limit   int64
+		token   string
+		invalid bool
+	}{
+		{query: "", limit: 0},
+		{query: "?limit=2", limit: 2},
+		{query: "?limit=2&continue=abc", limit: 2, token: "abc"},
+		{query: "?continue=abc", token: "abc"},
+		{query: "?limit=0", invalid: true},
+		{query: "?limit=-1", invalid: true},
+		{query: "?limit=two", invalid: true},
+	}
+
+	for _, tt := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("GET", "/disks"+tt.query, nil)
+
+		opts, errs := Options(ctx)
+		if tt.invalid {
+			if len(errs) != 1 || errs[0].Field != "limit" {
+				t.Errorf("Options(%s): errs = %v, want a limit error", tt.query, errs)
+			}
+			continue
+		}
+
+		if len(errs) > 0 {
+			t.Errorf("Options(%s): unexpected errs %v", tt.query, errs)
+		}
+
+		if opts.Limit != tt.limit || opts.Continue != tt.token {
+			t.Errorf("Options(%s) = limit %d, continue %q, want %d, %q", tt.query, opts.Limit, opts.Continue, tt.limit, tt.token)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		limit int64
+		token string
+		items []string
+		next  string
+	}{
+		{limit: 0, items: []string{"a", "b", "c", "d", "e"}},
+		{limit: 2, items: []string{"a", "b"}, next: "2"},
+		{limit: 2, token: "2", items: []string{"c", "d"}, next: "4"},
+		{limit: 2, token: "4", items: []string{"e"}},
+		{limit: 5, items: []string{"a", "b", "c", "d", "e"}},
+		{limit: 10, token: "3", items: []string{"d", "e"}},
+		{token: "5", items: []string{}},
+	}
+
+	for _, tt := range tests {
+		page, meta, errs := Page(items, metav1.ListOptions{Limit: tt.limit, Continue: tt.token})
+		if len(errs) > 0 {
+			t.Errorf("Page(limit %d, continue %q): unexpected errs %v", tt.limit, tt.token, errs)
+			continue
+		}
+
+		if !reflect.DeepEqual(page, tt.items) || meta.Continue != tt.next {
+			t.Errorf("Page(limit %d, continue %q) = %v, %q, want %v, %q", tt.limit, tt.token, page, meta.Continue, tt.items, tt.next)
+		}
+	}
+
+	for _, bad := range []string{"x", "-1", "6"} {
+		if _, _, errs := Page(items, metav1.ListOptions{Continue: bad}); len(errs) != 1 || errs[0].Field != "continue" {
+			t.Errorf("Page(continue %q): errs = %v, want a continue error", bad, errs)
+		}
+	}
+}
+
+// TestPageWalk follows continue tokens until the list is exhausted, the way a
+// client pages through a list.
+func TestPageWalk(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+	walked := []int{}
+
+	opts := metav1.ListOptions{Limit: 3}
+	for pages := 0; ; pages++ {
+		if pages > len(items) {
+			t.Fatalf("paging did not terminate")
+		}
+
+		page, meta, errs := Page(items, opts)
+		if len(errs) > 0 {
+			t.Fatalf("Page: %v", errs)
+		}
+
+		resp := New(page, meta)
+		if resp.Count > 3 {
+			t.Errorf("page of %d items exceeds the limit", resp.Count)
+		}
+		walked = append(walked, page.([]int)...)
+
+		if resp.Continue == "" {
+			break
+		}
+		opts.Continue = resp.Continue
+	}
+
+	if !reflect.DeepEqual(walked, items) {
+		t.Errorf("walked %v, want %v", walked, items)
+	}
+}
+
+func TestNew(t *testing.T) {
+	resp := New([]string{}, metav1.ListMeta{Continue: "abc", ResourceVersion: "42"})
+	if resp.Count != 0 || resp.Continue != "abc" || resp.ResourceVersion != "42" {
+		t.Errorf("New = %+v", resp)
+	}
+
+	resp = New([]int{1, 2, 3}, metav1.ListMeta{})
+	if resp.Count != 3 || resp.Continue != "" {
+		t.Errorf("New = %+v, want 3 items and no continue token", resp)
+	}
+}
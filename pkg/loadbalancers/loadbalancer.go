@@ -2,6 +2,7 @@ package loadbalancers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	corev1 "k8s.io/api/core/v1"
@@ -10,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
@@ -19,7 +22,7 @@ type ResponseLoadBalancer struct {
 	IP             string                 `json:"ip"`
 	Backends       []v1alpha1.Destination `json:"backends"`
 	Ports          []corev1.ServicePort   `json:"ports"`
-	BackendsStatus map[string]string      `json:backendsStatus"`
+	BackendsStatus map[string]string      `json:"backendsStatus"`
 	Health         string                 `json:"health"`
 }
 
@@ -31,21 +34,70 @@ type RequestLoadBalancer struct {
 
 func convertLoadBalancer2ResponseLoadBalancer(loadbalancer v1alpha1.LoadBalancer) *ResponseLoadBalancer {
 	ret := &ResponseLoadBalancer{
-		Name:     loadbalancer.GetName(),
-		State:    loadbalancer.Status.State,
-		IP:       loadbalancer.Status.IP,
-		Backends: loadbalancer.Status.Backends,
-		Ports:    loadbalancer.Spec.Ports,
+		Name:           loadbalancer.GetName(),
+		State:          loadbalancer.Status.State,
+		IP:             loadbalancer.Status.IP,
+		Backends:       loadbalancer.Status.Backends,
+		Ports:          loadbalancer.Spec.Ports,
 		BackendsStatus: loadbalancer.Status.BackendsStatus,
-		Health:   loadbalancer.Status.Health,
+		Health:         loadbalancer.Status.Health,
 	}
 
 	return ret
 }
 
+func validateRequestLoadBalancer(lb *RequestLoadBalancer) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", lb.Name)...)
+
+	if len(lb.Backends) == 0 {
+		errs = append(errs, validation.NewFieldError("backends", "at least one backend is required"))
+	}
+
+	if len(lb.Ports) == 0 {
+		errs = append(errs, validation.NewFieldError("ports", "at least one port is required"))
+	}
+
+	seen := map[string]bool{}
+	for i, port := range lb.Ports {
+		field := validation.Index("ports", i)
+		errs = append(errs, validation.ValidatePort(validation.Child(field, "port"), port.Port)...)
+		if port.TargetPort.IntValue() != 0 {
+			errs = append(errs, validation.ValidatePort(validation.Child(field, "targetPort"), int32(port.TargetPort.IntValue()))...)
+		}
+		errs = append(errs, validation.ValidateProtocol(validation.Child(field, "protocol"), port.Protocol)...)
+
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+
+		key := fmt.Sprintf("%d/%s", port.Port, protocol)
+		if seen[key] {
+			errs = append(errs, validation.NewFieldError(validation.Child(field, "port"), "duplicate port "+key))
+		}
+		seen[key] = true
+
+		if len(lb.Ports) > 1 && port.Name == "" {
+			errs = append(errs, validation.NewFieldError(validation.Child(field, "name"), "must be specified when multiple ports are defined"))
+		}
+	}
+
+	return errs
+}
+
 func GetAllLoadBalancers(ctx *gin.Context) {
 	namespace := "kubeberth"
-	loadbalancers, err := client.Clientset.LoadBalancers().LoadBalancers(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	loadbalancers, err := client.Clientset.LoadBalancers().LoadBalancers(namespace).List(context.TODO(), opts)
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -54,12 +106,12 @@ func GetAllLoadBalancers(ctx *gin.Context) {
 		return
 	}
 
-	var ret []*ResponseLoadBalancer
+	ret := []*ResponseLoadBalancer{}
 	for _, loadbalancer := range loadbalancers.Items {
 		ret = append(ret, convertLoadBalancer2ResponseLoadBalancer(loadbalancer))
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, loadbalancers.ListMeta))
 }
 
 func GetLoadBalancer(ctx *gin.Context) {
@@ -86,6 +138,14 @@ func CreateLoadBalancer(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateRequestLoadBalancer(&lb); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := lb.Name
 	namespace := "kubeberth"
 	backends := lb.Backends
@@ -122,6 +182,14 @@ func UpdateLoadBalancer(ctx *gin.Context) {
 		return
 	}
 
+	if errs := validateRequestLoadBalancer(&lb); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := lb.Name
 	namespace := "kubeberth"
 	backends := lb.Backends
@@ -0,0 +1,278 @@
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+)
+
+const (
+	StateRunning   = "Running"
+	StateSucceeded = "Succeeded"
+	StateFailed    = "Failed"
+
+	labelOperation = "kubeberth.io/operation"
+	dataKey        = "operation"
+)
+
+// Operations are kept in ConfigMaps so that every apiserver replica can report
+// the progress of work started by another one.
+type Operation struct {
+	ID        string      `json:"id"`
+	Kind      string      `json:"kind"`
+	Target    string      `json:"target"`
+	State     string      `json:"state"`
+	Step      string      `json:"step"`
+	Message   string      `json:"message,omitempty"`
+	Results   interface{} `json:"results,omitempty"`
+	StartedAt metav1.Time `json:"startedAt"`
+	UpdatedAt metav1.Time `json:"updatedAt"`
+
+	namespace string
+	mu        sync.Mutex
+}
+
+func Start(namespace, kind, target string, owner *metav1.OwnerReference) (*Operation, error) {
+	now := metav1.NewTime(time.Now())
+	op := &Operation{
+		Kind:      kind,
+		Target:    target,
+		State:     StateRunning,
+		Step:      "pending",
+		StartedAt: now,
+		UpdatedAt: now,
+		namespace: namespace,
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+
+	configmap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "operation-",
+			Namespace:    namespace,
+			Labels: map[string]string{
+				labelOperation: kind,
+			},
+		},
+		Data: map[string]string{
+			dataKey: string(data),
+		},
+	}
+
+	if owner != nil {
+		configmap.ObjectMeta.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+
+	ret, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Create(context.TODO(), configmap, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	op.ID = ret.ObjectMeta.Name
+	return op, nil
+}
+
+func (op *Operation) SetStep(step string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.Step = step
+	op.persist()
+}
+
+// Touch records that a long running operation is still making progress.
+func (op *Operation) Touch() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.persist()
+}
+
+// SetResults records per-object outcomes for operations that act on several
+// objects, such as a cascading delete.
+func (op *Operation) SetResults(results interface{}) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.Results = results
+	op.persist()
+}
+
+func (op *Operation) Succeed(message string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.State = StateSucceeded
+	op.Step = "done"
+	op.Message = message
+	op.persist()
+}
+
+func (op *Operation) Fail(err error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.State = StateFailed
+	op.Message = err.Error()
+	op.persist()
+}
+
+func (op *Operation) persist() {
+	if err := op.save(); err != nil {
+		klog.Errorf("operation %s: saving state: %s", op.ID, err.Error())
+	}
+}
+
+func (op *Operation) save() error {
+	op.UpdatedAt = metav1.NewTime(time.Now())
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := client.Kubernetes.CoreV1().ConfigMaps(op.namespace).Get(context.TODO(), op.ID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		configmap.Data = map[string]string{
+			dataKey: string(data),
+		}
+
+		_, err = client.Kubernetes.CoreV1().ConfigMaps(op.namespace).Update(context.TODO(), configmap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func convertConfigMap2Operation(configmap corev1.ConfigMap) (*Operation, error) {
+	op := &Operation{}
+	if err := json.Unmarshal([]byte(configmap.Data[dataKey]), op); err != nil {
+		return nil, err
+	}
+
+	op.ID = configmap.ObjectMeta.Name
+	op.namespace = configmap.ObjectMeta.Namespace
+	return op, nil
+}
+
+// Load returns the operation with the given ID, such as one recorded by
+// another replica.
+func Load(namespace, id string) (*Operation, error) {
+	configmap, _, err := getOperationConfigMap(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertConfigMap2Operation(*configmap)
+}
+
+func GetAllOperations(ctx *gin.Context) {
+	namespace := "kubeberth"
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	opts.LabelSelector = labelOperation
+	configmaps, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := []*Operation{}
+	for _, configmap := range configmaps.Items {
+		op, err := convertConfigMap2Operation(configmap)
+		if err != nil {
+			klog.Errorf("operation %s: %s", configmap.ObjectMeta.Name, err.Error())
+			continue
+		}
+		ret = append(ret, op)
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(ret, configmaps.ListMeta))
+}
+
+func getOperationConfigMap(namespace, name string) (*corev1.ConfigMap, int, error) {
+	configmap, err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if _, ok := configmap.ObjectMeta.Labels[labelOperation]; !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("operation %s not found", name)
+	}
+
+	return configmap, http.StatusOK, nil
+}
+
+func GetOperation(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	configmap, status, err := getOperationConfigMap(namespace, name)
+
+	if err != nil {
+		ctx.JSON(status, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	op, err := convertConfigMap2Operation(*configmap)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, op)
+}
+
+func DeleteOperation(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	if _, status, err := getOperationConfigMap(namespace, name); err != nil {
+		ctx.JSON(status, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	err := client.Kubernetes.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "ok",
+	})
+}
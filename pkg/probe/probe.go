@@ -0,0 +1,181 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatQCOW2   = "qcow2"
+	FormatISO     = "iso"
+	FormatRaw     = "raw"
+	FormatUnknown = ""
+)
+
+// ISO 9660 keeps its volume descriptor after 32KiB of system area, so this is
+// how much of the image has to be read to tell the formats apart.
+const (
+	isoMagicOffset = 32769
+	headerSize     = isoMagicOffset + 5
+)
+
+var (
+	qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+	isoMagic   = []byte("CD001")
+
+	// Compressed images cannot be told apart from their header alone.
+	compressedMagics = [][]byte{
+		{0x1f, 0x8b},                     // gzip
+		{0xfd, '7', 'z', 'X', 'Z', 0x00}, // xz
+		{0x28, 0xb5, 0x2f, 0xfd},         // zstd
+		{'B', 'Z', 'h'},                  // bzip2
+	}
+)
+
+var (
+	Client = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+)
+
+type Result struct {
+	Reachable     bool   `json:"reachable"`
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type"`
+	LastModified  string `json:"last_modified"`
+	Format        string `json:"format"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Probe inspects the image behind url with a HEAD request followed by a ranged
+// GET of its header. Failures are reported in the result rather than returned.
+func Probe(ctx context.Context, url string) *Result {
+	ret := &Result{
+		ContentLength: -1,
+	}
+
+	if err := head(ctx, url, ret); err != nil {
+		ret.Error = err.Error()
+	}
+
+	header, err := readHeader(ctx, url, ret)
+	if err != nil {
+		if ret.Error == "" {
+			ret.Error = err.Error()
+		}
+		return ret
+	}
+
+	ret.Reachable = true
+	ret.Error = ""
+	ret.Format = DetectFormat(header)
+
+	return ret
+}
+
+func head(ctx context.Context, url string, ret *Result) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+
+	ret.ContentLength = resp.ContentLength
+	ret.ContentType = resp.Header.Get("Content-Type")
+	ret.LastModified = resp.Header.Get("Last-Modified")
+
+	return nil
+}
+
+// readHeader fetches the first bytes of the image. Servers that ignore Range
+// answer with the whole body, which is cut off after the header.
+func readHeader(ctx context.Context, url string, ret *Result) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(headerSize-1))
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if ret.ContentLength < 0 {
+			ret.ContentLength = resp.ContentLength
+		}
+	case http.StatusPartialContent:
+		if ret.ContentLength < 0 {
+			ret.ContentLength = contentRangeSize(resp.Header.Get("Content-Range"))
+		}
+	default:
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	if ret.ContentType == "" {
+		ret.ContentType = resp.Header.Get("Content-Type")
+	}
+
+	if ret.LastModified == "" {
+		ret.LastModified = resp.Header.Get("Last-Modified")
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, headerSize))
+}
+
+func contentRangeSize(header string) int64 {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+// DetectFormat guesses the image format from its first bytes. Anything that is
+// neither qcow2, ISO 9660 nor a compressed stream is taken to be a raw image.
+func DetectFormat(header []byte) string {
+	if bytes.HasPrefix(header, qcow2Magic) {
+		return FormatQCOW2
+	}
+
+	if len(header) >= headerSize && bytes.Equal(header[isoMagicOffset:headerSize], isoMagic) {
+		return FormatISO
+	}
+
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(header, magic) {
+			return FormatUnknown
+		}
+	}
+
+	if len(header) == 0 {
+		return FormatUnknown
+	}
+
+	return FormatRaw
+}
@@ -0,0 +1,128 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func isoImage() []byte {
+	image := make([]byte, headerSize+1024)
+	copy(image[isoMagicOffset:], isoMagic)
+	return image
+}
+
+func TestProbe(t *testing.T) {
+	modified := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	images := map[string][]byte{
+		"/focal.qcow2":  append(append([]byte{}, qcow2Magic...), make([]byte, 4096)...),
+		"/focal.iso":    isoImage(),
+		"/focal.img":    bytes.Repeat([]byte{0xeb, 0x63, 0x90}, 20000),
+		"/focal.img.gz": append([]byte{0x1f, 0x8b}, make([]byte, 64)...),
+	}
+
+	// http.ServeContent answers HEAD and single ranges like a file server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		image, ok := images[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, r.URL.Path, modified, bytes.NewReader(image))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		format string
+	}{
+		{"/focal.qcow2", FormatQCOW2},
+		{"/focal.iso", FormatISO},
+		{"/focal.img", FormatRaw},
+		{"/focal.img.gz", FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		ret := Probe(context.Background(), server.URL+tt.path)
+		if !ret.Reachable || ret.Error != "" {
+			t.Errorf("Probe(%s): unreachable: %s", tt.path, ret.Error)
+			continue
+		}
+
+		if ret.Format != tt.format {
+			t.Errorf("Probe(%s): format = %q, want %q", tt.path, ret.Format, tt.format)
+		}
+
+		if ret.ContentLength != int64(len(images[tt.path])) {
+			t.Errorf("Probe(%s): content length = %d, want %d", tt.path, ret.ContentLength, len(images[tt.path]))
+		}
+
+		if ret.ContentType != "application/octet-stream" {
+			t.Errorf("Probe(%s): content type = %q", tt.path, ret.ContentType)
+		}
+
+		if ret.LastModified != modified.Format(http.TimeFormat) {
+			t.Errorf("Probe(%s): last modified = %q", tt.path, ret.LastModified)
+		}
+	}
+
+	ret := Probe(context.Background(), server.URL+"/missing.img")
+	if ret.Reachable || !strings.Contains(ret.Error, "404") {
+		t.Errorf("Probe(missing) = reachable %v, error %q, want unreachable with 404", ret.Reachable, ret.Error)
+	}
+}
+
+// TestProbeWithoutHeadOrRange covers servers that refuse HEAD and ignore
+// Range; the size then comes from the full GET response.
+func TestProbeWithoutHeadOrRange(t *testing.T) {
+	image := isoImage()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+		w.Write(image)
+	}))
+	defer server.Close()
+
+	ret := Probe(context.Background(), server.URL+"/focal.iso")
+	if !ret.Reachable || ret.Error != "" {
+		t.Fatalf("Probe: unreachable: %s", ret.Error)
+	}
+
+	if ret.Format != FormatISO {
+		t.Errorf("format = %q, want %q", ret.Format, FormatISO)
+	}
+
+	if ret.ContentLength != int64(len(image)) {
+		t.Errorf("content length = %d, want %d", ret.ContentLength, len(image))
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		format string
+	}{
+		{"empty", nil, FormatUnknown},
+		{"qcow2", qcow2Magic, FormatQCOW2},
+		{"short iso", isoImage()[:isoMagicOffset], FormatRaw},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, FormatUnknown},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, FormatUnknown},
+		{"bzip2", []byte("BZh91AY"), FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := DetectFormat(tt.header); got != tt.format {
+			t.Errorf("DetectFormat(%s) = %q, want %q", tt.name, got, tt.format)
+		}
+	}
+}
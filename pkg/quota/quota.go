@@ -0,0 +1,62 @@
+package quota
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+)
+
+var quotaNames = map[corev1.ResourceName][]corev1.ResourceName{
+	corev1.ResourceCPU: {
+		corev1.ResourceCPU,
+		corev1.ResourceRequestsCPU,
+		corev1.ResourceLimitsCPU,
+	},
+	corev1.ResourceMemory: {
+		corev1.ResourceMemory,
+		corev1.ResourceRequestsMemory,
+		corev1.ResourceLimitsMemory,
+	},
+	corev1.ResourceStorage: {
+		corev1.ResourceRequestsStorage,
+	},
+}
+
+// Check reports an error on field when growing resourceName by delta would exceed
+// any ResourceQuota in namespace. Shrinking never fails.
+func Check(namespace, field string, resourceName corev1.ResourceName, delta resource.Quantity) (validation.ErrorList, error) {
+	var errs validation.ErrorList
+	if delta.Sign() <= 0 {
+		return errs, nil
+	}
+
+	quotas, err := client.Kubernetes.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range quotas.Items {
+		for _, name := range quotaNames[resourceName] {
+			hard, ok := q.Status.Hard[name]
+			if !ok {
+				hard, ok = q.Spec.Hard[name]
+			}
+			if !ok {
+				continue
+			}
+
+			requested := q.Status.Used[name]
+			requested.Add(delta)
+			if requested.Cmp(hard) > 0 {
+				errs = append(errs, validation.NewFieldError(field, "exceeds quota "+q.ObjectMeta.Name+": "+string(name)+" would be "+requested.String()+", limited to "+hard.String()))
+			}
+		}
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,270 @@
+package references
+
+import (
+	"context"
+	"encoding/json"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type Reference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Usage lists what refers to an object, for the /usage endpoints.
+type Usage struct {
+	Name   string      `json:"name"`
+	UsedBy []Reference `json:"used_by"`
+}
+
+func ServersReferencingDisk(namespace, name string) ([]Reference, error) {
+	return findServers(namespace, func(server v1alpha1.Server) bool {
+		for _, disk := range server.Spec.Disks {
+			if disk.Name == name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func ServersReferencingISOImage(namespace, name string) ([]Reference, error) {
+	return findServers(namespace, func(server v1alpha1.Server) bool {
+		return server.Spec.ISOImage != nil && server.Spec.ISOImage.Name == name
+	})
+}
+
+func ServersReferencingCloudInit(namespace, name string) ([]Reference, error) {
+	return findServers(namespace, func(server v1alpha1.Server) bool {
+		if server.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate] == name {
+			return true
+		}
+		return server.Spec.CloudInit != nil && server.Spec.CloudInit.Name == name
+	})
+}
+
+func ServersReferencingKeyPair(namespace, name string) ([]Reference, error) {
+	return findServers(namespace, func(server v1alpha1.Server) bool {
+		var keypairs []string
+		if err := json.Unmarshal([]byte(server.ObjectMeta.Annotations[berth.AnnotationKeyPairs]), &keypairs); err != nil {
+			return false
+		}
+		for _, keypair := range keypairs {
+			if keypair == name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func CloudInitsReferencingCloudInit(namespace, name string) ([]Reference, error) {
+	cloudinits, err := client.Clientset.CloudInits().CloudInits(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Reference{}
+	for _, cloudinit := range cloudinits.Items {
+		var parts []string
+		if err := json.Unmarshal([]byte(cloudinit.ObjectMeta.Annotations[berth.AnnotationCloudInitParts]), &parts); err != nil {
+			continue
+		}
+		for _, part := range parts {
+			if part == name {
+				ret = append(ret, Reference{Kind: "CloudInit", Name: cloudinit.ObjectMeta.Name})
+				break
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func DisksReferencingDisk(namespace, name string) ([]Reference, error) {
+	return findDisks(namespace, func(disk v1alpha1.Disk) bool {
+		return disk.Spec.Source != nil && disk.Spec.Source.Disk != nil && disk.Spec.Source.Disk.Name == name
+	})
+}
+
+func DisksReferencingArchive(namespace, name string) ([]Reference, error) {
+	return findDisks(namespace, func(disk v1alpha1.Disk) bool {
+		return disk.Spec.Source != nil && disk.Spec.Source.Archive != nil && disk.Spec.Source.Archive.Name == name
+	})
+}
+
+// ISOImageUsage maps ISO image names to the servers using them, listing servers once
+// for the whole namespace.
+func ISOImageUsage(namespace string) (map[string][]Reference, error) {
+	servers, err := client.Clientset.Servers().Servers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string][]Reference{}
+	for _, server := range servers.Items {
+		if server.Spec.ISOImage != nil {
+			name := server.Spec.ISOImage.Name
+			ret[name] = append(ret[name], Reference{Kind: "Server", Name: server.ObjectMeta.Name})
+		}
+	}
+
+	return ret, nil
+}
+
+// ArchiveUsage maps archive names to the disks created from them.
+func ArchiveUsage(namespace string) (map[string][]Reference, error) {
+	disks, err := client.Clientset.Disks().Disks(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string][]Reference{}
+	for _, disk := range disks.Items {
+		if disk.Spec.Source != nil && disk.Spec.Source.Archive != nil {
+			name := disk.Spec.Source.Archive.Name
+			ret[name] = append(ret[name], Reference{Kind: "Disk", Name: disk.ObjectMeta.Name})
+		}
+	}
+
+	return ret, nil
+}
+
+func ReferencesToDisk(namespace, name string) ([]Reference, error) {
+	servers, err := ServersReferencingDisk(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	disks, err := DisksReferencingDisk(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(servers, disks...), nil
+}
+
+func ValidateServerReferences(namespace string, spec *v1alpha1.ServerSpec) (validation.ErrorList, error) {
+	var errs validation.ErrorList
+	for i, disk := range spec.Disks {
+		e, err := ValidateDiskReference(namespace, validation.Child(validation.Index("disks", i), "name"), disk.Name)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e...)
+	}
+
+	if spec.ISOImage != nil {
+		e, err := ValidateISOImageReference(namespace, "isoimage.name", spec.ISOImage.Name)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e...)
+	}
+
+	if spec.CloudInit != nil {
+		e, err := ValidateCloudInitReference(namespace, "cloudinit.name", spec.CloudInit.Name)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e...)
+	}
+
+	return errs, nil
+}
+
+func ValidateDiskSourceReferences(namespace string, source *berth.AttachedSource) (validation.ErrorList, error) {
+	var errs validation.ErrorList
+	if source == nil {
+		return errs, nil
+	}
+
+	if source.Archive != nil {
+		e, err := ValidateArchiveReference(namespace, "source.archive.name", source.Archive.Name)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e...)
+	}
+
+	if source.Disk != nil {
+		e, err := ValidateDiskReference(namespace, "source.disk.name", source.Disk.Name)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e...)
+	}
+
+	return errs, nil
+}
+
+func ValidateDiskReference(namespace, field, name string) (validation.ErrorList, error) {
+	_, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return notFound(field, "disk", name, err)
+}
+
+func ValidateArchiveReference(namespace, field, name string) (validation.ErrorList, error) {
+	_, err := client.Clientset.Archives().Archives(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return notFound(field, "archive", name, err)
+}
+
+func ValidateISOImageReference(namespace, field, name string) (validation.ErrorList, error) {
+	_, err := client.Clientset.ISOImages().ISOImages(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return notFound(field, "isoimage", name, err)
+}
+
+func ValidateCloudInitReference(namespace, field, name string) (validation.ErrorList, error) {
+	_, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return notFound(field, "cloudinit", name, err)
+}
+
+func notFound(field, kind, name string, err error) (validation.ErrorList, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	if apierrors.IsNotFound(err) {
+		return validation.ErrorList{validation.NewFieldError(field, kind+" "+name+" does not exist")}, nil
+	}
+
+	return nil, err
+}
+
+func findServers(namespace string, match func(v1alpha1.Server) bool) ([]Reference, error) {
+	servers, err := client.Clientset.Servers().Servers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Reference{}
+	for _, server := range servers.Items {
+		if match(server) {
+			ret = append(ret, Reference{Kind: "Server", Name: server.ObjectMeta.Name})
+		}
+	}
+
+	return ret, nil
+}
+
+func findDisks(namespace string, match func(v1alpha1.Disk) bool) ([]Reference, error) {
+	disks, err := client.Clientset.Disks().Disks(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []Reference{}
+	for _, disk := range disks.Items {
+		if match(disk) {
+			ret = append(ret, Reference{Kind: "Disk", Name: disk.ObjectMeta.Name})
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,240 @@
+package servers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const (
+	CascadeDisks     = "disks"
+	CascadeCloudInit = "cloudinit"
+
+	detachPollInterval = 2 * time.Second
+	detachTimeout      = 3 * time.Minute
+)
+
+type RequestDeleteServer struct {
+	Cascade []string `json:"cascade"`
+	Force   bool     `json:"force"`
+}
+
+type DeleteResult struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+func parseDeleteServerRequest(ctx *gin.Context) (*RequestDeleteServer, error) {
+	req := &RequestDeleteServer{}
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if cascade := ctx.Query("cascade"); cascade != "" {
+		req.Cascade = append(req.Cascade, strings.Split(cascade, ",")...)
+	}
+
+	if ctx.Query("force") == "true" {
+		req.Force = true
+	}
+
+	return req, nil
+}
+
+func validateRequestDeleteServer(req *RequestDeleteServer) validation.ErrorList {
+	var errs validation.ErrorList
+	for i, cascade := range req.Cascade {
+		switch strings.TrimSpace(cascade) {
+		case CascadeDisks, CascadeCloudInit:
+		default:
+			errs = append(errs, validation.NewFieldError(validation.Index("cascade", i), "must be one of disks or cloudinit"))
+		}
+	}
+
+	return errs
+}
+
+func (req *RequestDeleteServer) cascades(target string) bool {
+	for _, cascade := range req.Cascade {
+		if strings.TrimSpace(cascade) == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkServerDependents decides up front which of the server's dependents
+// the cascade may delete, so that the caller learns about dependents still in
+// use by other objects before the server is gone.
+func checkServerDependents(namespace string, server *v1alpha1.Server, req *RequestDeleteServer) ([]DeleteResult, []DeleteResult) {
+	name := server.ObjectMeta.Name
+	var pending, results []DeleteResult
+
+	if req.cascades(CascadeDisks) {
+		for _, disk := range server.Spec.Disks {
+			result := DeleteResult{Kind: "Disk", Name: disk.Name}
+			if !req.Force {
+				result = checkReferences(namespace, result, name, references.ReferencesToDisk)
+			}
+
+			if result.Result == "" {
+				pending = append(pending, result)
+			} else {
+				results = append(results, result)
+			}
+		}
+	}
+
+	if req.cascades(CascadeCloudInit) && server.Spec.CloudInit != nil {
+		result := DeleteResult{Kind: "CloudInit", Name: server.Spec.CloudInit.Name}
+		if !req.Force {
+			result = checkReferences(namespace, result, name, references.ServersReferencingCloudInit)
+		}
+
+		if result.Result == "" {
+			pending = append(pending, result)
+		} else {
+			results = append(results, result)
+		}
+	}
+
+	return pending, results
+}
+
+func checkReferences(namespace string, result DeleteResult, serverName string, referencing func(namespace, name string) ([]references.Reference, error)) DeleteResult {
+	refs, err := referencing(namespace, result.Name)
+	if err != nil {
+		result.Result = "failed"
+		result.Message = err.Error()
+		return result
+	}
+
+	if refs = excludeServer(refs, serverName); len(refs) > 0 {
+		result.Result = "skipped"
+		result.Message = "still referenced by " + describeReferences(refs)
+	}
+
+	return result
+}
+
+// deleteServerDependents runs as an operation after the server is deleted: it
+// waits for each disk to detach before deleting it, then deletes the
+// cloud-init.
+func deleteServerDependents(op *operations.Operation, namespace, serverName string, pending, results []DeleteResult, force bool) {
+	for _, result := range pending {
+		switch result.Kind {
+		case "Disk":
+			op.SetStep("waiting for disk " + result.Name + " to detach")
+			result = deleteDetachedDisk(namespace, serverName, result, force)
+		case "CloudInit":
+			op.SetStep("deleting cloudinit " + result.Name)
+			result = deleteUnusedCloudInit(namespace, serverName, result, force)
+		}
+
+		results = append(results, result)
+		op.SetResults(results)
+	}
+
+	for _, result := range results {
+		if result.Result == "failed" {
+			op.Fail(fmt.Errorf("some dependents could not be deleted"))
+			return
+		}
+	}
+
+	klog.Infof("server %s deleted with its dependents", serverName)
+	op.Succeed("deleted")
+}
+
+func deleteDetachedDisk(namespace, serverName string, result DeleteResult, force bool) DeleteResult {
+	name := result.Name
+	err := wait.PollImmediate(detachPollInterval, detachTimeout, func() (bool, error) {
+		disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return disk.Status.AttachedTo == "", nil
+	})
+	if err != nil {
+		result.Result = "failed"
+		result.Message = "waiting for disk to detach: " + err.Error()
+		return result
+	}
+
+	// Another server may have taken the disk while this one was going away.
+	if !force {
+		if result = checkReferences(namespace, result, serverName, references.ReferencesToDisk); result.Result != "" {
+			return result
+		}
+	}
+
+	return deleteResult(result, client.Clientset.Disks().Disks(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}))
+}
+
+func deleteUnusedCloudInit(namespace, serverName string, result DeleteResult, force bool) DeleteResult {
+	if !force {
+		if result = checkReferences(namespace, result, serverName, references.ServersReferencingCloudInit); result.Result != "" {
+			return result
+		}
+	}
+
+	return deleteResult(result, client.Clientset.CloudInits().CloudInits(namespace).Delete(context.TODO(), result.Name, metav1.DeleteOptions{}))
+}
+
+func deleteResult(result DeleteResult, err error) DeleteResult {
+	switch {
+	case err == nil:
+		result.Result = "deleted"
+	case apierrors.IsNotFound(err):
+		result.Result = "skipped"
+		result.Message = "not found"
+	default:
+		result.Result = "failed"
+		result.Message = err.Error()
+	}
+
+	return result
+}
+
+func excludeServer(refs []references.Reference, name string) []references.Reference {
+	ret := []references.Reference{}
+	for _, ref := range refs {
+		if ref.Kind == "Server" && ref.Name == name {
+			continue
+		}
+		ret = append(ret, ref)
+	}
+
+	return ret
+}
+
+func describeReferences(refs []references.Reference) string {
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Kind+"/"+ref.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
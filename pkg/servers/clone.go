@@ -0,0 +1,294 @@
+package servers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestCloneServer struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Running  bool   `json:"running"`
+}
+
+func validateRequestCloneServer(c *RequestCloneServer) validation.ErrorList {
+	var errs validation.ErrorList
+	if c.Name != "" {
+		errs = append(errs, validation.ValidateName("name", c.Name)...)
+	}
+
+	if c.Hostname != "" {
+		errs = append(errs, validation.ValidateHostname("hostname", c.Hostname)...)
+	}
+
+	return errs
+}
+
+func cloneDiskName(clone, disk string) string {
+	return clone + "-" + disk
+}
+
+// validateCloneNames checks the names of everything a clone creates, which are
+// derived from its name, before any of it is created.
+func validateCloneNames(cloneName string, source *v1alpha1.Server) validation.ErrorList {
+	names := []string{cloneName}
+	for _, attached := range source.Spec.Disks {
+		names = append(names, cloneDiskName(cloneName, attached.Name))
+	}
+
+	if _, ok := source.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]; ok {
+		names = append(names, renderedCloudInitName(cloneName))
+	}
+
+	return validateDerivedNames(names...)
+}
+
+// validateDerivedNames checks the names of objects made for a server, which
+// add a suffix to the server's name and may exceed the limits.
+func validateDerivedNames(names ...string) validation.ErrorList {
+	var errs validation.ErrorList
+	for _, name := range names {
+		for _, e := range validation.ValidateName("name", name) {
+			e.Message = "derived name " + name + ": " + e.Message
+			errs = append(errs, e)
+		}
+	}
+
+	return errs
+}
+
+func generateMACAddress() (string, error) {
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", buf[0], buf[1], buf[2]), nil
+}
+
+func CloneServer(ctx *gin.Context) {
+	var c RequestCloneServer
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&c); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "request invalid: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if errs := validateRequestCloneServer(&c); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	source, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	cloneName := c.Name
+	if cloneName == "" {
+		cloneName = name + "-clone-" + utilrand.String(5)
+	}
+
+	hostname := c.Hostname
+	if hostname == "" {
+		hostname = cloneName
+	}
+
+	if errs := validateCloneNames(cloneName, source); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	// Nothing is created for a clone whose name is taken, so that the
+	// rollback below only ever removes what this request made.
+	exists, err := serverExists(namespace, cloneName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if exists {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "server " + cloneName + " already exists",
+		})
+		return
+	}
+
+	macAddress, err := generateMACAddress()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	labels := map[string]string{
+		berth.LabelServer: cloneName,
+	}
+
+	created := []references.Reference{}
+	rollback := func() {
+		for i := len(created) - 1; i >= 0; i-- {
+			if err := deleteReference(namespace, created[i]); err != nil {
+				klog.Errorf("clone %s: rolling back %s/%s: %s", cloneName, created[i].Kind, created[i].Name, err.Error())
+			}
+		}
+	}
+
+	disks := []berth.AttachedDisk{}
+	for _, attached := range source.Spec.Disks {
+		sourceDisk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), attached.Name, metav1.GetOptions{})
+		if err != nil {
+			rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "clone error: " + err.Error(),
+			})
+			return
+		}
+
+		disk := &v1alpha1.Disk{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cloneDiskName(cloneName, attached.Name),
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Spec: v1alpha1.DiskSpec{
+				Size: sourceDisk.Spec.Size,
+				Source: &berth.AttachedSource{
+					Disk: &berth.AttachedDisk{
+						Name: attached.Name,
+					},
+				},
+			},
+		}
+
+		ret, err := client.Clientset.Disks().Disks(namespace).Create(context.TODO(), disk, metav1.CreateOptions{})
+		if err != nil {
+			rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "clone error: creating disk: " + err.Error(),
+			})
+			return
+		}
+		created = append(created, references.Reference{Kind: "Disk", Name: ret.ObjectMeta.Name})
+
+		disks = append(disks, berth.AttachedDisk{
+			Name: ret.ObjectMeta.Name,
+		})
+	}
+
+	running := c.Running
+	server := &v1alpha1.Server{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cloneName,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{},
+		},
+		Spec: v1alpha1.ServerSpec{
+			Running:    &running,
+			CPU:        source.Spec.CPU,
+			Memory:     source.Spec.Memory,
+			MACAddress: macAddress,
+			Hostname:   hostname,
+			Hosting:    source.Spec.Hosting,
+			Disks:      disks,
+			CloudInit:  source.Spec.CloudInit,
+		},
+	}
+
+	if instanceType, ok := source.ObjectMeta.Annotations[berth.AnnotationInstanceType]; ok {
+		server.ObjectMeta.Annotations[berth.AnnotationInstanceType] = instanceType
+	}
+
+	// A rendered cloud-init is rendered again for the clone's name, hostname
+	// and MAC address.
+	var rendered *v1alpha1.CloudInit
+	if _, ok := source.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]; ok {
+		server.Spec.CloudInit = templateCloudInit(source)
+
+		var errs validation.ErrorList
+		rendered, errs, err = renderCloudInit(namespace, server, "", templateVariables(source), serverKeyPairs(source))
+		if err == nil && len(errs) > 0 {
+			rollback()
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+				"message": "request invalid",
+				"errors":  errs,
+			})
+			return
+		}
+
+		if err == nil && rendered != nil {
+			err = createRenderedCloudInit(namespace, rendered)
+		}
+
+		if apierrors.IsAlreadyExists(err) {
+			rollback()
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "clone error: cloudinit " + rendered.ObjectMeta.Name + " already exists",
+			})
+			return
+		}
+
+		if err != nil {
+			rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "clone error: rendering cloudinit: " + err.Error(),
+			})
+			return
+		}
+
+		if rendered != nil {
+			created = append(created, references.Reference{Kind: "CloudInit", Name: rendered.ObjectMeta.Name})
+		}
+	}
+
+	ret, err := client.Clientset.Servers().Servers(namespace).Create(context.TODO(), server, metav1.CreateOptions{})
+	if err != nil {
+		rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "clone error: creating server: " + err.Error(),
+		})
+		return
+	}
+
+	if rendered != nil {
+		if err := saveRenderedCloudInit(namespace, rendered, serverOwnerReference(ret)); err != nil {
+			klog.Errorf("clone %s: adopting rendered cloudinit: %s", cloneName, err.Error())
+		}
+	}
+
+	ctx.JSON(http.StatusCreated, convertServer2ResponseServer(*ret))
+}
@@ -0,0 +1,41 @@
+package servers
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+func TestValidateCloneNames(t *testing.T) {
+	source := &v1alpha1.Server{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				berth.AnnotationCloudInitTemplate: "base",
+			},
+		},
+		Spec: v1alpha1.ServerSpec{
+			Disks: []berth.AttachedDisk{{Name: "root"}, {Name: "data"}},
+		},
+	}
+
+	if errs := validateCloneNames("web-2", source); len(errs) > 0 {
+		t.Errorf("validateCloneNames(web-2) = %v, want no errors", errs)
+	}
+
+	// 60 characters fit a name but not the "-root" and "-cloudinit" suffixes.
+	long := strings.Repeat("a", 60)
+	errs := validateCloneNames(long, source)
+	if len(errs) != 3 {
+		t.Fatalf("validateCloneNames(%s) = %v, want 3 errors", long, errs)
+	}
+
+	for _, e := range errs {
+		if e.Field != "name" {
+			t.Errorf("error field = %q, want name", e.Field)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package servers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/cloudinits"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/keypairs"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+func renderedCloudInitName(server string) string {
+	return server + "-cloudinit"
+}
+
+// renderCloudInit renders the cloud-init referenced by server for that server
+// when it is a template, composed from parts or kept in a Secret, variables
+// were given or key pairs have to be merged in. The server is pointed at the
+// rendered copy and remembers the template, variables and key pairs in
+// annotations; an empty template annotation means the copy was made from key
+// pairs alone. The copy still has to be saved with createRenderedCloudInit or
+// saveRenderedCloudInit. It returns nil when the cloud-init can be used as is.
+func renderCloudInit(namespace string, server *v1alpha1.Server, ip string, vars map[string]string, keyPairs []string) (*v1alpha1.CloudInit, validation.ErrorList, error) {
+	name := ""
+	templateSpec := v1alpha1.CloudInitSpec{}
+	secrets := []string{}
+	composed := false
+	if server.Spec.CloudInit != nil {
+		name = server.Spec.CloudInit.Name
+		template, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resolved, errs, err := cloudinits.Resolve(namespace, template)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, e := range errs {
+			e.Field = "cloudinit." + e.Field
+		}
+
+		if len(errs) > 0 {
+			return nil, errs, nil
+		}
+
+		templateSpec = resolved.Spec
+		secrets = resolved.SecretNames()
+		composed = cloudinits.IsComposed(template)
+	}
+
+	if server.Spec.CloudInit == nil && len(keyPairs) == 0 {
+		return nil, nil, nil
+	}
+
+	// Data kept in a Secret is always rendered, since the operator only reads
+	// the spec.
+	if !cloudinits.IsTemplate(templateSpec) && !composed && len(secrets) == 0 && len(vars) == 0 && len(keyPairs) == 0 {
+		return nil, nil, nil
+	}
+
+	keys, errs, err := keypairs.PublicKeys(namespace, "keypairs", keyPairs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	spec, errs := cloudinits.Render(templateSpec, &cloudinits.TemplateData{
+		Name:       server.ObjectMeta.Name,
+		Hostname:   server.Spec.Hostname,
+		MACAddress: server.Spec.MACAddress,
+		IP:         ip,
+		Vars:       vars,
+	})
+
+	for _, e := range errs {
+		e.Field = "cloudinit." + e.Field
+	}
+
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	spec.UserData, err = cloudinits.AddAuthorizedKeys(spec.UserData, keys)
+	if err != nil {
+		return nil, validation.ErrorList{validation.NewFieldError("cloudinit.user_data", "adding keypairs: "+err.Error())}, nil
+	}
+
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if server.ObjectMeta.Annotations == nil {
+		server.ObjectMeta.Annotations = map[string]string{}
+	}
+	server.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate] = name
+	server.ObjectMeta.Annotations[berth.AnnotationCloudInitVariables] = string(data)
+
+	if len(keyPairs) > 0 {
+		data, err := json.Marshal(keyPairs)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.ObjectMeta.Annotations[berth.AnnotationKeyPairs] = string(data)
+	}
+
+	rendered := &v1alpha1.CloudInit{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      renderedCloudInitName(server.ObjectMeta.Name),
+			Namespace: namespace,
+			Labels: map[string]string{
+				berth.LabelServer: server.ObjectMeta.Name,
+			},
+			Annotations: map[string]string{
+				berth.AnnotationCloudInitTemplate: name,
+			},
+		},
+		Spec: spec,
+	}
+
+	if err := cloudinits.MarkRenderedFrom(rendered, secrets); err != nil {
+		return nil, nil, err
+	}
+
+	server.Spec.CloudInit = &berth.AttachedCloudInit{
+		Name: rendered.ObjectMeta.Name,
+	}
+
+	return rendered, nil, nil
+}
+
+// templateCloudInit returns what a server's cloud-init was rendered from, or
+// nil if it was made from key pairs alone.
+func templateCloudInit(server *v1alpha1.Server) *berth.AttachedCloudInit {
+	if template := server.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]; template != "" {
+		return &berth.AttachedCloudInit{
+			Name: template,
+		}
+	}
+
+	return nil
+}
+
+// serverKeyPairs returns the key pairs merged into a server's cloud-init.
+func serverKeyPairs(server *v1alpha1.Server) []string {
+	keyPairs := []string{}
+	if data, ok := server.ObjectMeta.Annotations[berth.AnnotationKeyPairs]; ok {
+		if err := json.Unmarshal([]byte(data), &keyPairs); err != nil {
+			return []string{}
+		}
+	}
+
+	return keyPairs
+}
+
+// templateVariables returns the variables a server's cloud-init was rendered with.
+func templateVariables(server *v1alpha1.Server) map[string]string {
+	vars := map[string]string{}
+	if data, ok := server.ObjectMeta.Annotations[berth.AnnotationCloudInitVariables]; ok {
+		if err := json.Unmarshal([]byte(data), &vars); err != nil {
+			return map[string]string{}
+		}
+	}
+
+	return vars
+}
+
+// createRenderedCloudInit saves the rendered cloud-init of a server that is
+// being created. It never replaces an existing cloud-init, so a failed create
+// only has its own copy to remove.
+func createRenderedCloudInit(namespace string, rendered *v1alpha1.CloudInit) error {
+	_, err := client.Clientset.CloudInits().CloudInits(namespace).Create(context.TODO(), rendered, metav1.CreateOptions{})
+	return err
+}
+
+// saveRenderedCloudInit creates or refreshes a rendered cloud-init. It refuses
+// to overwrite a cloud-init that was not rendered from a template.
+func saveRenderedCloudInit(namespace string, rendered *v1alpha1.CloudInit, owner *metav1.OwnerReference) error {
+	if owner != nil {
+		rendered.ObjectMeta.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Clientset.CloudInits().CloudInits(namespace).Get(context.TODO(), rendered.ObjectMeta.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.Clientset.CloudInits().CloudInits(namespace).Create(context.TODO(), rendered, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, ok := current.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]; !ok {
+			return fmt.Errorf("cloudinit %s already exists and was not rendered from a template", rendered.ObjectMeta.Name)
+		}
+
+		current.ObjectMeta.Labels = rendered.ObjectMeta.Labels
+		current.ObjectMeta.Annotations = rendered.ObjectMeta.Annotations
+		if owner != nil {
+			current.ObjectMeta.OwnerReferences = rendered.ObjectMeta.OwnerReferences
+		}
+		current.Spec = rendered.Spec
+
+		_, err = client.Clientset.CloudInits().CloudInits(namespace).Update(context.TODO(), current, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// deleteRenderedCloudInit removes the server's rendered cloud-init, if any.
+func deleteRenderedCloudInit(namespace, server string) error {
+	err := client.Clientset.CloudInits().CloudInits(namespace).Delete(context.TODO(), renderedCloudInitName(server), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
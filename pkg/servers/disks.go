@@ -0,0 +1,136 @@
+package servers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestAttachDisk struct {
+	Name string `json:"name" binding:"required"`
+}
+
+func AttachDisk(ctx *gin.Context) {
+	var d RequestAttachDisk
+	if err := ctx.ShouldBindJSON(&d); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validation.ValidateName("name", d.Name); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	disk, err := client.Clientset.Disks().Disks(namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+
+	if apierrors.IsNotFound(err) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  validation.ErrorList{validation.NewFieldError("name", "disk "+d.Name+" does not exist")},
+		})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if disk.Status.AttachedTo != "" && disk.Status.AttachedTo != name {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "disk " + d.Name + " is attached to " + disk.Status.AttachedTo,
+		})
+		return
+	}
+
+	refs, err := references.ServersReferencingDisk(namespace, d.Name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	for _, ref := range refs {
+		if ref.Name != name {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "disk " + d.Name + " is attached to " + ref.Name,
+			})
+			return
+		}
+	}
+
+	ret, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		for _, attached := range server.Spec.Disks {
+			if attached.Name == d.Name {
+				return &statusError{http.StatusConflict, fmt.Sprintf("disk %s is already attached to %s", d.Name, name)}
+			}
+		}
+
+		server.Spec.Disks = append(server.Spec.Disks, berth.AttachedDisk{
+			Name: d.Name,
+		})
+		return nil
+	})
+
+	if err != nil {
+		ctx.JSON(modifyServerStatus(err), gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertServer2ResponseServer(*ret))
+}
+
+func DetachDisk(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	diskName := ctx.Param("disk")
+
+	ret, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		disks := []berth.AttachedDisk{}
+		for _, attached := range server.Spec.Disks {
+			if attached.Name != diskName {
+				disks = append(disks, attached)
+			}
+		}
+
+		if len(disks) == len(server.Spec.Disks) {
+			return &statusError{http.StatusNotFound, fmt.Sprintf("disk %s is not attached to %s", diskName, name)}
+		}
+
+		server.Spec.Disks = disks
+		return nil
+	})
+
+	if err != nil {
+		ctx.JSON(modifyServerStatus(err), gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertServer2ResponseServer(*ret))
+}
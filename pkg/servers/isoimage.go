@@ -0,0 +1,150 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+// ISOImageStateReady is the status.state the operator gives an ISO image once
+// it is imported, the same state it gives disks. test.sh waits for it before
+// inserting an image.
+const (
+	ISOImageStateReady = "Created"
+)
+
+type RequestInsertISOImage struct {
+	Name    string `json:"name"    binding:"required"`
+	Restart bool   `json:"restart"`
+}
+
+type ResponseISOImageChange struct {
+	Server    *ResponseServer       `json:"server"`
+	Operation *operations.Operation `json:"operation,omitempty"`
+}
+
+func InsertISOImage(ctx *gin.Context) {
+	var iso RequestInsertISOImage
+	if err := ctx.ShouldBindJSON(&iso); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validation.ValidateName("name", iso.Name); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	isoimage, err := client.Clientset.ISOImages().ISOImages(namespace).Get(context.TODO(), iso.Name, metav1.GetOptions{})
+
+	if apierrors.IsNotFound(err) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  validation.ErrorList{validation.NewFieldError("name", "isoimage "+iso.Name+" does not exist")},
+		})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if isoimage.Status.State != ISOImageStateReady {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "isoimage " + iso.Name + " is not ready (state: " + isoimage.Status.State + ")",
+		})
+		return
+	}
+
+	ret, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		server.Spec.ISOImage = &berth.AttachedISOImage{
+			Name: iso.Name,
+		}
+		return nil
+	})
+
+	if err != nil {
+		ctx.JSON(modifyServerStatus(err), gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	respondISOImageChange(ctx, namespace, ret, iso.Restart)
+}
+
+func EjectISOImage(ctx *gin.Context) {
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	restart := ctx.Query("restart") == "true"
+
+	ret, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		if server.Spec.ISOImage == nil {
+			return &statusError{http.StatusNotFound, "no isoimage is inserted in " + name}
+		}
+
+		server.Spec.ISOImage = nil
+		return nil
+	})
+
+	if err != nil {
+		ctx.JSON(modifyServerStatus(err), gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	respondISOImageChange(ctx, namespace, ret, restart)
+}
+
+func respondISOImageChange(ctx *gin.Context, namespace string, server *v1alpha1.Server, restart bool) {
+	name := server.ObjectMeta.Name
+	ret := &ResponseISOImageChange{
+		Server: convertServer2ResponseServer(*server),
+	}
+
+	if !restart || server.Spec.Running == nil || !*server.Spec.Running {
+		ctx.JSON(http.StatusOK, ret)
+		return
+	}
+
+	op, err := operations.Start(namespace, "restart", "servers/"+name, serverOwnerReference(server))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret.Operation = op
+	ctx.JSON(http.StatusAccepted, ret)
+
+	go func() {
+		op.SetStep("restarting")
+		if err := restartServer(namespace, name); err != nil {
+			op.Fail(err)
+			return
+		}
+		op.Succeed("restarted")
+	}()
+}
@@ -0,0 +1,134 @@
+package servers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+const (
+	StateRunning = "Running"
+	StateStopped = "Stopped"
+
+	statePollInterval = 3 * time.Second
+	stateTimeout      = 5 * time.Minute
+)
+
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func serverOwnerReference(server *v1alpha1.Server) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
+		APIVersion: berth.APIVersion,
+		Kind:       "Server",
+		Name:       server.ObjectMeta.Name,
+		UID:        server.ObjectMeta.UID,
+	}
+}
+
+// modifyServer applies mutate to the latest version of the server, retrying on
+// conflicts with concurrent writers such as the operator.
+func modifyServer(namespace, name string, mutate func(*v1alpha1.Server) error) (*v1alpha1.Server, error) {
+	var ret *v1alpha1.Server
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if err := mutate(server); err != nil {
+			return err
+		}
+
+		ret, err = client.Clientset.Servers().Servers(namespace).Update(context.TODO(), server, metav1.UpdateOptions{})
+		return err
+	})
+
+	return ret, err
+}
+
+func setRunning(namespace, name string, running bool) error {
+	_, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		server.Spec.Running = &running
+		return nil
+	})
+
+	return err
+}
+
+func waitForState(namespace, name, state string) error {
+	err := wait.PollImmediate(statePollInterval, stateTimeout, func() (bool, error) {
+		server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return server.Status.State == state, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out waiting for server %s to be %s", name, state)
+	}
+
+	return err
+}
+
+func modifyServerStatus(err error) int {
+	if e, ok := err.(*statusError); ok {
+		return e.status
+	}
+
+	if apierrors.IsNotFound(err) {
+		return http.StatusNotFound
+	}
+
+	if apierrors.IsConflict(err) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// restartServer stops and starts a running server. Once the server has been
+// asked to stop, any failure sets Running back to true so that the server is
+// not left stopped.
+func restartServer(namespace, name string) error {
+	if err := setRunning(namespace, name, false); err != nil {
+		return err
+	}
+
+	if err := waitForState(namespace, name, StateStopped); err != nil {
+		return restoreRunning(namespace, name, true, err)
+	}
+
+	if err := setRunning(namespace, name, true); err != nil {
+		return restoreRunning(namespace, name, true, err)
+	}
+
+	return waitForState(namespace, name, StateRunning)
+}
+
+// restoreRunning sets Running back to its value before a failed stop and start
+// sequence, and reports the restore failure along with the original one.
+func restoreRunning(namespace, name string, running bool, err error) error {
+	if e := setRunning(namespace, name, running); e != nil {
+		return fmt.Errorf("%s; setting running back to %t: %s", err.Error(), running, e.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,257 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/cloudinits"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/instancetypes"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestProvisionServer struct {
+	Name         string             `json:"name"          binding:"required"`
+	Running      bool               `json:"running"`
+	InstanceType string             `json:"instance_type"`
+	CPU          *resource.Quantity `json:"cpu"`
+	Memory       *resource.Quantity `json:"memory"`
+	MACAddress   string             `json:"mac_address"`
+	Hostname     string             `json:"hostname"      binding:"required"`
+	Hosting      string             `json:"hosting"`
+	Archive      string             `json:"archive"`
+	ISOImage     string             `json:"isoimage"`
+	DiskSize     string             `json:"disk_size"     binding:"required"`
+	UserData     string             `json:"user_data"`
+	NetworkData  string             `json:"network_data"`
+}
+
+type ResponseProvisionServer struct {
+	Server  *ResponseServer        `json:"server"`
+	Created []references.Reference `json:"created"`
+}
+
+// ServerCollectionAction dispatches custom methods such as POST /servers:provision.
+// gin treats the colon as the start of a wildcard, so the action arrives as
+// ":provision". The wildcard also matches paths such as /serversFOO; anything
+// but a known action is answered as if the route did not exist.
+func ServerCollectionAction(ctx *gin.Context) {
+	switch ctx.Param("action") {
+	case ":provision":
+		ProvisionServer(ctx)
+	default:
+		ctx.String(http.StatusNotFound, "404 page not found")
+	}
+}
+
+func validateRequestProvisionServer(p *RequestProvisionServer) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", p.Name)...)
+	errs = append(errs, validation.ValidateQuantity("cpu", p.CPU)...)
+	errs = append(errs, validation.ValidateQuantity("memory", p.Memory)...)
+	errs = append(errs, validation.ValidateMACAddress("mac_address", p.MACAddress)...)
+	errs = append(errs, validation.ValidateHostname("hostname", p.Hostname)...)
+	errs = append(errs, validation.ValidateQuantityString("disk_size", p.DiskSize)...)
+
+	if p.Archive != "" && p.ISOImage != "" {
+		errs = append(errs, validation.NewFieldError("archive", "only one of archive or isoimage may be specified"))
+	}
+
+	if p.Archive != "" {
+		errs = append(errs, validation.ValidateName("archive", p.Archive)...)
+	}
+
+	if p.ISOImage != "" {
+		errs = append(errs, validation.ValidateName("isoimage", p.ISOImage)...)
+	}
+
+	errs = append(errs, cloudinits.ValidateSpec(v1alpha1.CloudInitSpec{
+		UserData:    p.UserData,
+		NetworkData: p.NetworkData,
+	})...)
+
+	return errs
+}
+
+func ProvisionServer(ctx *gin.Context) {
+	var p RequestProvisionServer
+	var errs validation.ErrorList
+	if err := ctx.ShouldBindJSON(&p); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	p.CPU, p.Memory, errs = instancetypes.Resolve("instance_type", p.InstanceType, p.CPU, p.Memory)
+	if errs = append(errs, validateRequestProvisionServer(&p)...); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := p.Name
+	namespace := "kubeberth"
+	running := p.Running
+	labels := map[string]string{
+		berth.LabelServer: name,
+	}
+
+	var source *berth.AttachedSource
+	if p.Archive != "" {
+		source = &berth.AttachedSource{
+			Archive: &berth.AttachedArchive{
+				Name: p.Archive,
+			},
+		}
+	}
+
+	errs, err := references.ValidateDiskSourceReferences(namespace, source)
+	if err == nil && p.ISOImage != "" {
+		var e validation.ErrorList
+		e, err = references.ValidateISOImageReference(namespace, "isoimage", p.ISOImage)
+		errs = append(errs, e...)
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	spec := v1alpha1.ServerSpec{
+		Running:    &running,
+		CPU:        p.CPU,
+		Memory:     p.Memory,
+		MACAddress: p.MACAddress,
+		Hostname:   p.Hostname,
+		Hosting:    p.Hosting,
+		Disks: []berth.AttachedDisk{
+			{Name: name},
+		},
+	}
+
+	if p.ISOImage != "" {
+		spec.ISOImage = &berth.AttachedISOImage{
+			Name: p.ISOImage,
+		}
+	}
+
+	created := []references.Reference{}
+	rollback := func() {
+		for i := len(created) - 1; i >= 0; i-- {
+			if err := deleteReference(namespace, created[i]); err != nil {
+				klog.Errorf("provision %s: rolling back %s/%s: %s", name, created[i].Kind, created[i].Name, err.Error())
+			}
+		}
+	}
+
+	if p.UserData != "" || p.NetworkData != "" {
+		cloudinit := &v1alpha1.CloudInit{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Spec: v1alpha1.CloudInitSpec{
+				UserData:    p.UserData,
+				NetworkData: p.NetworkData,
+			},
+		}
+
+		if _, err := client.Clientset.CloudInits().CloudInits(namespace).Create(context.TODO(), cloudinit, metav1.CreateOptions{}); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "provision error: creating cloudinit: " + err.Error(),
+			})
+			return
+		}
+		created = append(created, references.Reference{Kind: "CloudInit", Name: name})
+
+		spec.CloudInit = &berth.AttachedCloudInit{
+			Name: name,
+		}
+	}
+
+	disk := &v1alpha1.Disk{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: v1alpha1.DiskSpec{
+			Size:   p.DiskSize,
+			Source: source,
+		},
+	}
+
+	if _, err := client.Clientset.Disks().Disks(namespace).Create(context.TODO(), disk, metav1.CreateOptions{}); err != nil {
+		rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "provision error: creating disk: " + err.Error(),
+		})
+		return
+	}
+	created = append(created, references.Reference{Kind: "Disk", Name: name})
+
+	server := &v1alpha1.Server{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{},
+		},
+		Spec: spec,
+	}
+
+	if p.InstanceType != "" {
+		server.ObjectMeta.Annotations[berth.AnnotationInstanceType] = p.InstanceType
+	}
+
+	ret, err := client.Clientset.Servers().Servers(namespace).Create(context.TODO(), server, metav1.CreateOptions{})
+	if err != nil {
+		rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "provision error: creating server: " + err.Error(),
+		})
+		return
+	}
+	created = append(created, references.Reference{Kind: "Server", Name: name})
+
+	ctx.JSON(http.StatusCreated, &ResponseProvisionServer{
+		Server:  convertServer2ResponseServer(*ret),
+		Created: created,
+	})
+}
+
+func deleteReference(namespace string, ref references.Reference) error {
+	switch ref.Kind {
+	case "CloudInit":
+		return client.Clientset.CloudInits().CloudInits(namespace).Delete(context.TODO(), ref.Name, metav1.DeleteOptions{})
+	case "Disk":
+		return client.Clientset.Disks().Disks(namespace).Delete(context.TODO(), ref.Name, metav1.DeleteOptions{})
+	case "Server":
+		return client.Clientset.Servers().Servers(namespace).Delete(context.TODO(), ref.Name, metav1.DeleteOptions{})
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package servers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerCollectionActionUnknown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.POST("/servers:action", ServerCollectionAction)
+
+	for _, path := range []string{"/serversFOO", "/servers:FOO", "/servers:provisionX", "/servers-provision"} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("POST %s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
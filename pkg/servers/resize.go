@@ -0,0 +1,171 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/instancetypes"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/quota"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
+	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
+)
+
+type RequestResizeServer struct {
+	InstanceType string             `json:"instance_type"`
+	CPU          *resource.Quantity `json:"cpu"`
+	Memory       *resource.Quantity `json:"memory"`
+	Restart      bool               `json:"restart"`
+}
+
+func ResizeServer(ctx *gin.Context) {
+	var r RequestResizeServer
+	var errs validation.ErrorList
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	r.CPU, r.Memory, errs = instancetypes.Resolve("instance_type", r.InstanceType, r.CPU, r.Memory)
+	errs = append(errs, validation.ValidateQuantity("cpu", r.CPU)...)
+	errs = append(errs, validation.ValidateQuantity("memory", r.Memory)...)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := ctx.Param("name")
+	namespace := "kubeberth"
+	server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	for _, res := range []struct {
+		field   string
+		name    corev1.ResourceName
+		current *resource.Quantity
+		desired *resource.Quantity
+	}{
+		{"cpu", corev1.ResourceCPU, server.Spec.CPU, r.CPU},
+		{"memory", corev1.ResourceMemory, server.Spec.Memory, r.Memory},
+	} {
+		delta := res.desired.DeepCopy()
+		if res.current != nil {
+			delta.Sub(*res.current)
+		}
+
+		e, err := quota.Check(namespace, res.field, res.name, delta)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+		errs = append(errs, e...)
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	op, err := operations.Start(namespace, "resize", "servers/"+name, serverOwnerReference(server))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	wasRunning := server.Spec.Running != nil && *server.Spec.Running
+	ctx.JSON(http.StatusAccepted, op)
+
+	go resizeServer(op, namespace, name, &r, wasRunning)
+}
+
+func resizeServer(op *operations.Operation, namespace, name string, r *RequestResizeServer, wasRunning bool) {
+	restart := r.Restart && wasRunning
+
+	if restart {
+		op.SetStep("stopping")
+		if err := setRunning(namespace, name, false); err != nil {
+			op.Fail(err)
+			return
+		}
+
+		if err := waitForState(namespace, name, StateStopped); err != nil {
+			op.Fail(restoreRunning(namespace, name, true, err))
+			return
+		}
+	}
+
+	op.SetStep("resizing")
+	_, err := modifyServer(namespace, name, func(server *v1alpha1.Server) error {
+		server.Spec.CPU = r.CPU
+		server.Spec.Memory = r.Memory
+
+		if server.ObjectMeta.Annotations == nil {
+			server.ObjectMeta.Annotations = map[string]string{}
+		}
+
+		if r.InstanceType != "" {
+			server.ObjectMeta.Annotations[berth.AnnotationInstanceType] = r.InstanceType
+		} else {
+			delete(server.ObjectMeta.Annotations, berth.AnnotationInstanceType)
+		}
+
+		return nil
+	})
+	if err != nil {
+		if restart {
+			err = restoreRunning(namespace, name, true, err)
+		}
+		op.Fail(err)
+		return
+	}
+
+	if !restart {
+		message := "resized"
+		if wasRunning {
+			message = "resized; changes take effect after the server is restarted"
+		}
+		op.Succeed(message)
+		return
+	}
+
+	op.SetStep("starting")
+	if err := setRunning(namespace, name, true); err != nil {
+		op.Fail(restoreRunning(namespace, name, true, err))
+		return
+	}
+
+	if err := waitForState(namespace, name, StateRunning); err != nil {
+		op.Fail(err)
+		return
+	}
+
+	klog.Infof("server %s resized to cpu=%s memory=%s", name, r.CPU.String(), r.Memory.String())
+	op.Succeed("resized and restarted")
+}
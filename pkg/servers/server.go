@@ -4,59 +4,83 @@ import (
 	"context"
 	"net/http"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/kubeberth/kubeberth-apiserver/pkg/berth"
 	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/instancetypes"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/operations"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/references"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/validation"
 	"github.com/kubeberth/kubeberth-operator/api/v1alpha1"
 )
 
 type ResponseServer struct {
-	Name       string                   `json:"name"`
-	State      string                   `json:"state"`
-	Running    bool                     `json:"running"`
-	CPU        *resource.Quantity       `json:"cpu"`
-	Memory     *resource.Quantity       `json:"memory"`
-	MACAddress string                   `json:"mac_address"`
-	IP         string                   `json:"ip"`
-	Hostname   string                   `json:"hostname"`
-	Hosting    string                   `json:"hosting"`
-	Disks      []berth.AttachedDisk     `json:"disks"`
-	ISOImage   *berth.AttachedISOImage  `json:"isoimage"`
-	CloudInit  *berth.AttachedCloudInit `json:"cloudinit"`
+	Name         string                   `json:"name"`
+	State        string                   `json:"state"`
+	Running      bool                     `json:"running"`
+	InstanceType string                   `json:"instance_type"`
+	CPU          *resource.Quantity       `json:"cpu"`
+	Memory       *resource.Quantity       `json:"memory"`
+	MACAddress   string                   `json:"mac_address"`
+	IP           string                   `json:"ip"`
+	Hostname     string                   `json:"hostname"`
+	Hosting      string                   `json:"hosting"`
+	Disks        []berth.AttachedDisk     `json:"disks"`
+	ISOImage     *berth.AttachedISOImage  `json:"isoimage"`
+	CloudInit    *berth.AttachedCloudInit `json:"cloudinit"`
+
+	CloudInitTemplate  string            `json:"cloudinit_template,omitempty"`
+	CloudInitVariables map[string]string `json:"cloudinit_variables,omitempty"`
+	KeyPairs           []string          `json:"keypairs"`
 }
 
 type RequestServer struct {
-	Name       string                   `json:"name"         binding:"required"`
-	Running    bool                     `json:"running"`
-	CPU        *resource.Quantity       `json:"cpu"          binding:"required"`
-	Memory     *resource.Quantity       `json:"memory"       binding:"required"`
-	MACAddress string                   `json:"mac_address"`
-	Hostname   string                   `json:"hostname"     binding:"required"`
-	Hosting    string                   `json:"hosting"`
-	IP         string                   `json:"ip"`
-	Disks      []berth.AttachedDisk     `json:"disks"`
-	ISOImage   *berth.AttachedISOImage  `json:"isoimage"`
-	CloudInit  *berth.AttachedCloudInit `json:"cloudinit"`
+	Name         string                   `json:"name"          binding:"required"`
+	Running      bool                     `json:"running"`
+	InstanceType string                   `json:"instance_type"`
+	CPU          *resource.Quantity       `json:"cpu"`
+	Memory       *resource.Quantity       `json:"memory"`
+	MACAddress   string                   `json:"mac_address"`
+	Hostname     string                   `json:"hostname"      binding:"required"`
+	Hosting      string                   `json:"hosting"`
+	IP           string                   `json:"ip"`
+	Disks        []berth.AttachedDisk     `json:"disks"`
+	ISOImage     *berth.AttachedISOImage  `json:"isoimage"`
+	CloudInit    *berth.AttachedCloudInit `json:"cloudinit"`
+
+	// CloudInitVariables are passed to a templated cloud-init as .Vars.
+	CloudInitVariables map[string]string `json:"cloudinit_variables"`
+
+	// KeyPairs are added to the authorized keys of the default user.
+	KeyPairs []string `json:"keypairs"`
 }
 
 func convertServer2ResponseServer(server v1alpha1.Server) *ResponseServer {
 	ret := &ResponseServer{
-		Name:       server.ObjectMeta.Name,
-		State:      server.Status.State,
-		Running:    *server.Spec.Running,
-		CPU:        server.Spec.CPU,
-		Memory:     server.Spec.Memory,
-		MACAddress: server.Spec.MACAddress,
-		IP:         server.Status.IP,
-		Hostname:   server.Spec.Hostname,
-		Hosting:    server.Spec.Hosting,
-		Disks:      []berth.AttachedDisk{},
-		ISOImage:   &berth.AttachedISOImage{},
-		CloudInit:  &berth.AttachedCloudInit{},
+		Name:         server.ObjectMeta.Name,
+		State:        server.Status.State,
+		Running:      *server.Spec.Running,
+		InstanceType: server.ObjectMeta.Annotations[berth.AnnotationInstanceType],
+		CPU:          server.Spec.CPU,
+		Memory:       server.Spec.Memory,
+		MACAddress:   server.Spec.MACAddress,
+		IP:           server.Status.IP,
+		Hostname:     server.Spec.Hostname,
+		Hosting:      server.Spec.Hosting,
+		Disks:        []berth.AttachedDisk{},
+		ISOImage:     &berth.AttachedISOImage{},
+		CloudInit:    &berth.AttachedCloudInit{},
+	}
+
+	if ret.InstanceType == "" {
+		ret.InstanceType = instancetypes.Match(server.Spec.CPU, server.Spec.Memory)
 	}
 
 	if ret.Hosting == "" {
@@ -75,12 +99,68 @@ func convertServer2ResponseServer(server v1alpha1.Server) *ResponseServer {
 		ret.CloudInit.Name = server.Spec.CloudInit.Name
 	}
 
+	if template, ok := server.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]; ok {
+		ret.CloudInitTemplate = template
+		ret.CloudInitVariables = templateVariables(&server)
+	}
+
+	ret.KeyPairs = serverKeyPairs(&server)
+
 	return ret
 }
 
+func validateRequestServer(s *RequestServer) validation.ErrorList {
+	var errs validation.ErrorList
+	errs = append(errs, validation.ValidateName("name", s.Name)...)
+	errs = append(errs, validation.ValidateQuantity("cpu", s.CPU)...)
+	errs = append(errs, validation.ValidateQuantity("memory", s.Memory)...)
+	errs = append(errs, validation.ValidateMACAddress("mac_address", s.MACAddress)...)
+	errs = append(errs, validation.ValidateHostname("hostname", s.Hostname)...)
+	errs = append(errs, validation.ValidateIP("ip", s.IP)...)
+
+	seenKeyPairs := map[string]bool{}
+	for i, keyPair := range s.KeyPairs {
+		field := validation.Index("keypairs", i)
+		errs = append(errs, validation.ValidateName(field, keyPair)...)
+		if seenKeyPairs[keyPair] {
+			errs = append(errs, validation.NewFieldError(field, "keypair "+keyPair+" is given more than once"))
+		}
+		seenKeyPairs[keyPair] = true
+	}
+
+	seen := map[string]bool{}
+	for i, disk := range s.Disks {
+		field := validation.Child(validation.Index("disks", i), "name")
+		errs = append(errs, validation.ValidateName(field, disk.Name)...)
+		if seen[disk.Name] {
+			errs = append(errs, validation.NewFieldError(field, "disk "+disk.Name+" is attached more than once"))
+		}
+		seen[disk.Name] = true
+	}
+
+	if s.ISOImage != nil {
+		errs = append(errs, validation.ValidateName("isoimage.name", s.ISOImage.Name)...)
+	}
+
+	if s.CloudInit != nil {
+		errs = append(errs, validation.ValidateName("cloudinit.name", s.CloudInit.Name)...)
+	}
+
+	return errs
+}
+
 func GetAllServers(ctx *gin.Context) {
 	namespace := "kubeberth"
-	servers, err := client.Clientset.Servers().Servers(namespace).List(context.TODO(), metav1.ListOptions{})
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	servers, err := client.Clientset.Servers().Servers(namespace).List(context.TODO(), opts)
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -89,12 +169,12 @@ func GetAllServers(ctx *gin.Context) {
 		return
 	}
 
-	var ret []*ResponseServer
+	ret := []*ResponseServer{}
 	for _, server := range servers.Items {
 		ret = append(ret, convertServer2ResponseServer(server))
 	}
 
-	ctx.JSON(http.StatusOK, ret)
+	ctx.JSON(http.StatusOK, lists.New(ret, servers.ListMeta))
 }
 
 func GetServer(ctx *gin.Context) {
@@ -112,8 +192,18 @@ func GetServer(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, convertServer2ResponseServer(*server))
 }
 
+func serverExists(namespace, name string) (bool, error) {
+	_, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
 func CreateServer(ctx *gin.Context) {
 	var s RequestServer
+	var errs validation.ErrorList
 	if err := ctx.ShouldBindJSON(&s); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "request invalid: " + err.Error(),
@@ -121,22 +211,32 @@ func CreateServer(ctx *gin.Context) {
 		return
 	}
 
-	name       := s.Name
-	namespace  := "kubeberth"
-	running    := s.Running
-	cpu        := s.CPU
-	memory     := s.Memory
+	s.CPU, s.Memory, errs = instancetypes.Resolve("instance_type", s.InstanceType, s.CPU, s.Memory)
+	if errs = append(errs, validateRequestServer(&s)...); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := s.Name
+	namespace := "kubeberth"
+	running := s.Running
+	cpu := s.CPU
+	memory := s.Memory
 	macAddress := s.MACAddress
-	hostname   := s.Hostname
-	hosting    := s.Hosting
-	disks      := s.Disks
-	isoimage   := s.ISOImage
-	cloudinit  := s.CloudInit
+	hostname := s.Hostname
+	hosting := s.Hosting
+	disks := s.Disks
+	isoimage := s.ISOImage
+	cloudinit := s.CloudInit
 
 	server := &v1alpha1.Server{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{},
 		},
 		Spec: v1alpha1.ServerSpec{
 			Running:    &running,
@@ -151,19 +251,111 @@ func CreateServer(ctx *gin.Context) {
 		},
 	}
 
+	if s.InstanceType != "" {
+		server.ObjectMeta.Annotations[berth.AnnotationInstanceType] = s.InstanceType
+	}
+
+	errs, err := references.ValidateServerReferences(namespace, &server.Spec)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	rendered, errs, err := renderCloudInit(namespace, server, s.IP, s.CloudInitVariables, s.KeyPairs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	if rendered != nil {
+		if errs := validateDerivedNames(rendered.ObjectMeta.Name); len(errs) > 0 {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+				"message": "request invalid",
+				"errors":  errs,
+			})
+			return
+		}
+
+		// The rendered copy is named after the server, so it must not be
+		// written for a server that exists already.
+		exists, err := serverExists(namespace, name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		if exists {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "server " + name + " already exists",
+			})
+			return
+		}
+
+		err = createRenderedCloudInit(namespace, rendered)
+		if apierrors.IsAlreadyExists(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "cloudinit " + rendered.ObjectMeta.Name + " already exists",
+			})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	ret, err := client.Clientset.Servers().Servers(namespace).Create(context.TODO(), server, metav1.CreateOptions{})
 	if err != nil {
+		// Only the rendered copy created above is removed.
+		if rendered != nil {
+			if err := deleteRenderedCloudInit(namespace, name); err != nil {
+				klog.Errorf("server %s: removing rendered cloudinit: %s", name, err.Error())
+			}
+		}
+
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
 		})
 		return
 	}
 
+	// The rendered cloud-init goes away with the server.
+	if rendered != nil {
+		if err := saveRenderedCloudInit(namespace, rendered, serverOwnerReference(ret)); err != nil {
+			klog.Errorf("server %s: adopting rendered cloudinit: %s", name, err.Error())
+		}
+	}
+
 	ctx.JSON(http.StatusCreated, convertServer2ResponseServer(*ret))
 }
 
 func UpdateServer(ctx *gin.Context) {
 	var s RequestServer
+	var errs validation.ErrorList
 	if err := ctx.ShouldBindJSON(&s); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "request invalid: " + err.Error(),
@@ -171,17 +363,26 @@ func UpdateServer(ctx *gin.Context) {
 		return
 	}
 
-	name       := s.Name
-	namespace  := "kubeberth"
-	running    := s.Running
-	cpu        := s.CPU
-	memory     := s.Memory
+	s.CPU, s.Memory, errs = instancetypes.Resolve("instance_type", s.InstanceType, s.CPU, s.Memory)
+	if errs = append(errs, validateRequestServer(&s)...); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	name := s.Name
+	namespace := "kubeberth"
+	running := s.Running
+	cpu := s.CPU
+	memory := s.Memory
 	macAddress := s.MACAddress
-	hostname   := s.Hostname
-	hosting    := s.Hosting
-	disks      := s.Disks
-	isoimage   := s.ISOImage
-	cloudinit  := s.CloudInit
+	hostname := s.Hostname
+	hosting := s.Hosting
+	disks := s.Disks
+	isoimage := s.ISOImage
+	cloudinit := s.CloudInit
 
 	server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 
@@ -192,6 +393,23 @@ func UpdateServer(ctx *gin.Context) {
 		return
 	}
 
+	// A server read back from the API refers to its rendered cloud-init; map
+	// that back to the template it was rendered from.
+	_, wasRendered := server.ObjectMeta.Annotations[berth.AnnotationCloudInitTemplate]
+	if cloudinit != nil && cloudinit.Name == renderedCloudInitName(name) && wasRendered {
+		cloudinit = templateCloudInit(server)
+	}
+
+	vars := s.CloudInitVariables
+	if vars == nil && wasRendered {
+		vars = templateVariables(server)
+	}
+
+	keyPairs := s.KeyPairs
+	if keyPairs == nil && wasRendered {
+		keyPairs = serverKeyPairs(server)
+	}
+
 	spec := v1alpha1.ServerSpec{
 		Running:    &running,
 		CPU:        cpu,
@@ -206,6 +424,60 @@ func UpdateServer(ctx *gin.Context) {
 
 	server.Spec = spec
 
+	if s.InstanceType != "" {
+		if server.ObjectMeta.Annotations == nil {
+			server.ObjectMeta.Annotations = map[string]string{}
+		}
+		server.ObjectMeta.Annotations[berth.AnnotationInstanceType] = s.InstanceType
+	} else {
+		delete(server.ObjectMeta.Annotations, berth.AnnotationInstanceType)
+	}
+
+	delete(server.ObjectMeta.Annotations, berth.AnnotationCloudInitTemplate)
+	delete(server.ObjectMeta.Annotations, berth.AnnotationCloudInitVariables)
+	delete(server.ObjectMeta.Annotations, berth.AnnotationKeyPairs)
+
+	errs, err = references.ValidateServerReferences(namespace, &server.Spec)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	rendered, errs, err := renderCloudInit(namespace, server, s.IP, vars, keyPairs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "update error: " + err.Error(),
+		})
+		return
+	}
+
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	if rendered != nil {
+		if err := saveRenderedCloudInit(namespace, rendered, serverOwnerReference(server)); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "update error: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	ret, err := client.Clientset.Servers().Servers(namespace).Update(context.TODO(), server, metav1.UpdateOptions{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -214,14 +486,62 @@ func UpdateServer(ctx *gin.Context) {
 		return
 	}
 
+	if rendered == nil && wasRendered {
+		if err := deleteRenderedCloudInit(namespace, name); err != nil {
+			klog.Errorf("server %s: removing rendered cloudinit: %s", name, err.Error())
+		}
+	}
+
 	ctx.JSON(http.StatusCreated, convertServer2ResponseServer(*ret))
 }
 
 func DeleteServer(ctx *gin.Context) {
+	req, err := parseDeleteServerRequest(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "request invalid: " + err.Error(),
+		})
+		return
+	}
+
+	if errs := validateRequestDeleteServer(req); len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
 	name := ctx.Param("name")
 	namespace := "kubeberth"
-	err := client.Clientset.Servers().Servers(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 
+	if len(req.Cascade) == 0 {
+		err := client.Clientset.Servers().Servers(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+		})
+		return
+	}
+
+	server, err := client.Clientset.Servers().Servers(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	pending, results := checkServerDependents(namespace, server, req)
+
+	op, err := operations.Start(namespace, "delete", "servers/"+name, nil)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error: " + err.Error(),
@@ -229,7 +549,18 @@ func DeleteServer(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "ok",
-	})
+	err = client.Clientset.Servers().Servers(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil {
+		op.Fail(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	results = append([]DeleteResult{{Kind: "Server", Name: name, Result: "deleted"}}, results...)
+	op.SetResults(results)
+	ctx.JSON(http.StatusAccepted, op)
+
+	go deleteServerDependents(op, namespace, name, pending, results, req.Force)
 }
@@ -0,0 +1,88 @@
+package storageclasses
+
+import (
+	"context"
+	"net/http"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kubeberth/kubeberth-apiserver/pkg/client"
+	"github.com/kubeberth/kubeberth-apiserver/pkg/lists"
+)
+
+const annotationDefaultClass = "storageclass.kubernetes.io/is-default-class"
+
+type ResponseStorageClass struct {
+	Name                 string `json:"name"`
+	Provisioner          string `json:"provisioner"`
+	Default              bool   `json:"default"`
+	ReclaimPolicy        string `json:"reclaim_policy"`
+	VolumeBindingMode    string `json:"volume_binding_mode"`
+	AllowVolumeExpansion bool   `json:"allow_volume_expansion"`
+}
+
+func convertStorageClass2ResponseStorageClass(sc storagev1.StorageClass) *ResponseStorageClass {
+	ret := &ResponseStorageClass{
+		Name:        sc.ObjectMeta.Name,
+		Provisioner: sc.Provisioner,
+		Default:     sc.ObjectMeta.Annotations[annotationDefaultClass] == "true",
+	}
+
+	if sc.ReclaimPolicy != nil {
+		ret.ReclaimPolicy = string(*sc.ReclaimPolicy)
+	}
+
+	if sc.VolumeBindingMode != nil {
+		ret.VolumeBindingMode = string(*sc.VolumeBindingMode)
+	}
+
+	if sc.AllowVolumeExpansion != nil {
+		ret.AllowVolumeExpansion = *sc.AllowVolumeExpansion
+	}
+
+	return ret
+}
+
+func GetAllStorageClasses(ctx *gin.Context) {
+	opts, errs := lists.Options(ctx)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "request invalid",
+			"errors":  errs,
+		})
+		return
+	}
+
+	storageClasses, err := client.Kubernetes.StorageV1().StorageClasses().List(context.TODO(), opts)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ret := []*ResponseStorageClass{}
+	for _, sc := range storageClasses.Items {
+		ret = append(ret, convertStorageClass2ResponseStorageClass(sc))
+	}
+
+	ctx.JSON(http.StatusOK, lists.New(ret, storageClasses.ListMeta))
+}
+
+func GetStorageClass(ctx *gin.Context) {
+	name := ctx.Param("name")
+	sc, err := client.Kubernetes.StorageV1().StorageClasses().Get(context.TODO(), name, metav1.GetOptions{})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertStorageClass2ResponseStorageClass(*sc))
+}
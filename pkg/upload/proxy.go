@@ -0,0 +1,119 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatRaw   = "raw"
+	FormatQCOW2 = "qcow2"
+)
+
+type Target struct {
+	Namespace string
+	Disk      string
+	Format    string
+}
+
+// Proxy writes image data into the volume backing a disk. Offsets are absolute
+// positions in the image so that interrupted uploads can be resumed.
+type Proxy interface {
+	Write(ctx context.Context, target Target, offset int64, body io.Reader) (int64, error)
+	Finalize(ctx context.Context, target Target, size int64) error
+}
+
+var (
+	DefaultProxy Proxy
+)
+
+// HTTPProxy talks to an upload server that accepts
+//
+//	PUT  {endpoint}/{namespace}/{disk}?format=...&offset=...   (chunk body)
+//	POST {endpoint}/{namespace}/{disk}/complete?size=...
+//
+// which is easy to stand in for locally with a small file server.
+type HTTPProxy struct {
+	Endpoint string
+	Token    string
+	Client   *http.Client
+}
+
+func NewHTTPProxy(endpoint, token string) *HTTPProxy {
+	return &HTTPProxy{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Token:    token,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (p *HTTPProxy) url(target Target, suffix string, query url.Values) string {
+	return p.Endpoint + "/" + url.PathEscape(target.Namespace) + "/" + url.PathEscape(target.Disk) + suffix + "?" + query.Encode()
+}
+
+func (p *HTTPProxy) do(req *http.Request) error {
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload proxy: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+func (p *HTTPProxy) Write(ctx context.Context, target Target, offset int64, body io.Reader) (int64, error) {
+	counter := &countingReader{r: body}
+	query := url.Values{
+		"format": {target.Format},
+		"offset": {strconv.FormatInt(offset, 10)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.url(target, "", query), counter)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	err = p.do(req)
+	return counter.n, err
+}
+
+func (p *HTTPProxy) Finalize(ctx context.Context, target Target, size int64) error {
+	query := url.Values{
+		"format": {target.Format},
+		"size":   {strconv.FormatInt(size, 10)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url(target, "/complete", query), nil)
+	if err != nil {
+		return err
+	}
+
+	return p.do(req)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// standIn is a local upload server that keeps images in memory. Chunks must
+// be written in order, as a real upload server would require.
+type standIn struct {
+	mu        sync.Mutex
+	images    map[string][]byte
+	completed map[string]int64
+	token     string
+}
+
+func newStandIn(token string) (*standIn, *httptest.Server) {
+	s := &standIn{
+		images:    map[string][]byte{},
+		completed: map[string]int64{},
+		token:     token,
+	}
+
+	return s, httptest.NewServer(s)
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil || offset != int64(len(s.images[path])) {
+			http.Error(w, "unexpected offset", http.StatusConflict)
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.images[path] = append(s.images[path], data...)
+	case http.MethodPost:
+		path = strings.TrimSuffix(path, "/complete")
+		size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		if err != nil || size != int64(len(s.images[path])) {
+			http.Error(w, "size mismatch", http.StatusUnprocessableEntity)
+			return
+		}
+		s.completed[path] = size
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPProxyChunkedUpload(t *testing.T) {
+	s, server := newStandIn("secret")
+	defer server.Close()
+
+	proxy := NewHTTPProxy(server.URL+"/", "secret")
+	target := Target{Namespace: "kubeberth", Disk: "test", Format: FormatRaw}
+	image := bytes.Repeat([]byte("kubeberth"), 1000)
+
+	var offset int64
+	for _, chunk := range [][]byte{image[:4000], image[4000:8000], image[8000:]} {
+		n, err := proxy.Write(context.Background(), target, offset, bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatalf("Write at %d: %v", offset, err)
+		}
+		if n != int64(len(chunk)) {
+			t.Fatalf("Write at %d wrote %d bytes, want %d", offset, n, len(chunk))
+		}
+		offset += n
+	}
+
+	if err := proxy.Finalize(context.Background(), target, offset); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	if !bytes.Equal(s.images["kubeberth/test"], image) {
+		t.Errorf("uploaded image differs from the original")
+	}
+
+	if s.completed["kubeberth/test"] != int64(len(image)) {
+		t.Errorf("completed size = %d, want %d", s.completed["kubeberth/test"], len(image))
+	}
+}
+
+func TestHTTPProxyErrors(t *testing.T) {
+	_, server := newStandIn("secret")
+	defer server.Close()
+
+	target := Target{Namespace: "kubeberth", Disk: "test", Format: FormatRaw}
+
+	unauthorized := NewHTTPProxy(server.URL, "wrong")
+	if _, err := unauthorized.Write(context.Background(), target, 0, strings.NewReader("data")); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Write with a wrong token: err = %v, want 401", err)
+	}
+
+	proxy := NewHTTPProxy(server.URL, "secret")
+	if _, err := proxy.Write(context.Background(), target, 10, strings.NewReader("data")); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Write out of order: err = %v, want 409", err)
+	}
+
+	if err := proxy.Finalize(context.Background(), target, 10); err == nil || !strings.Contains(err.Error(), "422") {
+		t.Errorf("Finalize with a wrong size: err = %v, want 422", err)
+	}
+}
@@ -0,0 +1,182 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+type ErrorList []*FieldError
+
+func NewFieldError(field, message string) *FieldError {
+	return &FieldError{
+		Field:   field,
+		Message: message,
+	}
+}
+
+// NewPositionError points at a line and column within a document-valued field
+// such as cloud-init user data. A column of zero means it is not known.
+func NewPositionError(field string, line, column int, message string) *FieldError {
+	return &FieldError{
+		Field:   field,
+		Message: message,
+		Line:    line,
+		Column:  column,
+	}
+}
+
+func (el ErrorList) Error() string {
+	var msgs []string
+	for _, e := range el {
+		msgs = append(msgs, e.Field+": "+e.Message)
+	}
+
+	return strings.Join(msgs, ", ")
+}
+
+func Index(field string, i int) string {
+	return fmt.Sprintf("%s[%d]", field, i)
+}
+
+func Child(field, child string) string {
+	return field + "." + child
+}
+
+func ValidateName(field, name string) ErrorList {
+	var errs ErrorList
+	if name == "" {
+		return append(errs, NewFieldError(field, "must not be empty"))
+	}
+
+	for _, msg := range k8svalidation.IsDNS1123Label(name) {
+		errs = append(errs, NewFieldError(field, msg))
+	}
+
+	return errs
+}
+
+func ValidateHostname(field, hostname string) ErrorList {
+	var errs ErrorList
+	if hostname == "" {
+		return append(errs, NewFieldError(field, "must not be empty"))
+	}
+
+	for _, msg := range k8svalidation.IsDNS1123Subdomain(hostname) {
+		errs = append(errs, NewFieldError(field, msg))
+	}
+
+	return errs
+}
+
+func ValidateQuantity(field string, quantity *resource.Quantity) ErrorList {
+	var errs ErrorList
+	if quantity == nil {
+		return append(errs, NewFieldError(field, "must be specified"))
+	}
+
+	if quantity.Sign() <= 0 {
+		errs = append(errs, NewFieldError(field, "must be greater than zero"))
+	}
+
+	return errs
+}
+
+func ValidateQuantityString(field, quantity string) ErrorList {
+	var errs ErrorList
+	if quantity == "" {
+		return append(errs, NewFieldError(field, "must not be empty"))
+	}
+
+	q, err := resource.ParseQuantity(quantity)
+	if err != nil {
+		return append(errs, NewFieldError(field, "invalid quantity: "+quantity))
+	}
+
+	return ValidateQuantity(field, &q)
+}
+
+func ValidateMACAddress(field, macAddress string) ErrorList {
+	var errs ErrorList
+	if macAddress == "" {
+		return errs
+	}
+
+	hw, err := net.ParseMAC(macAddress)
+	if err != nil || len(hw) != 6 {
+		return append(errs, NewFieldError(field, "must be a 48-bit MAC address like 52:54:00:12:34:56"))
+	}
+
+	if hw[0]&0x01 != 0 {
+		errs = append(errs, NewFieldError(field, "must be a unicast MAC address"))
+	}
+
+	return errs
+}
+
+func ValidateIP(field, ip string) ErrorList {
+	var errs ErrorList
+	if ip == "" {
+		return errs
+	}
+
+	if net.ParseIP(ip) == nil {
+		errs = append(errs, NewFieldError(field, "must be a valid IP address"))
+	}
+
+	return errs
+}
+
+func ValidatePort(field string, port int32) ErrorList {
+	var errs ErrorList
+	for _, msg := range k8svalidation.IsValidPortNum(int(port)) {
+		errs = append(errs, NewFieldError(field, msg))
+	}
+
+	return errs
+}
+
+func ValidateProtocol(field string, protocol corev1.Protocol) ErrorList {
+	var errs ErrorList
+	switch protocol {
+	case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+	default:
+		errs = append(errs, NewFieldError(field, "must be one of TCP, UDP or SCTP"))
+	}
+
+	return errs
+}
+
+func ValidateRepository(field, repository string) ErrorList {
+	var errs ErrorList
+	if repository == "" {
+		return append(errs, NewFieldError(field, "must not be empty"))
+	}
+
+	u, err := url.Parse(repository)
+	if err != nil {
+		return append(errs, NewFieldError(field, "invalid URL: "+err.Error()))
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		errs = append(errs, NewFieldError(field, "scheme must be http or https"))
+	}
+
+	if u.Host == "" {
+		errs = append(errs, NewFieldError(field, "must contain a host"))
+	}
+
+	return errs
+}
@@ -0,0 +1,214 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// check reports errs against the message a test case expects, where an empty
+// message means no errors.
+func check(t *testing.T, name string, errs ErrorList, message string) {
+	t.Helper()
+
+	if message == "" {
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errs %v", name, errs)
+		}
+		return
+	}
+
+	if len(errs) == 0 {
+		t.Errorf("%s: no errors, want %q", name, message)
+		return
+	}
+
+	for _, e := range errs {
+		if e.Field != "field" {
+			t.Errorf("%s: error on %s, want field", name, e.Field)
+		}
+	}
+
+	if !strings.Contains(errs.Error(), message) {
+		t.Errorf("%s: errs %v, want %q", name, errs, message)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"test", ""},
+		{"test-01", ""},
+		{"0test", ""},
+		{"", "must not be empty"},
+		{"Test", "a lowercase RFC 1123 label"},
+		{"test.example", "a lowercase RFC 1123 label"},
+		{"-test", "a lowercase RFC 1123 label"},
+		{"test_01", "a lowercase RFC 1123 label"},
+		{strings.Repeat("a", 63), ""},
+		{strings.Repeat("a", 64), "must be no more than 63 characters"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateName("+tt.name+")", ValidateName("field", tt.name), tt.message)
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		message  string
+	}{
+		{"test", ""},
+		{"test.example.com", ""},
+		{"", "must not be empty"},
+		{"Test.example.com", "a lowercase RFC 1123 subdomain"},
+		{"test..example.com", "a lowercase RFC 1123 subdomain"},
+		{"test_01.example.com", "a lowercase RFC 1123 subdomain"},
+		{strings.Repeat("a", 254), "must be no more than 253 characters"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateHostname("+tt.hostname+")", ValidateHostname("field", tt.hostname), tt.message)
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+
+	tests := []struct {
+		name     string
+		quantity *resource.Quantity
+		message  string
+	}{
+		{"2", quantity("2"), ""},
+		{"500m", quantity("500m"), ""},
+		{"4Gi", quantity("4Gi"), ""},
+		{"nil", nil, "must be specified"},
+		{"0", quantity("0"), "must be greater than zero"},
+		{"-1Gi", quantity("-1Gi"), "must be greater than zero"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateQuantity("+tt.name+")", ValidateQuantity("field", tt.quantity), tt.message)
+	}
+}
+
+func TestValidateQuantityString(t *testing.T) {
+	tests := []struct {
+		quantity string
+		message  string
+	}{
+		{"32Gi", ""},
+		{"1.5G", ""},
+		{"", "must not be empty"},
+		{"32GB", "invalid quantity: 32GB"},
+		{"large", "invalid quantity: large"},
+		{"0Gi", "must be greater than zero"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateQuantityString("+tt.quantity+")", ValidateQuantityString("field", tt.quantity), tt.message)
+	}
+}
+
+func TestValidateMACAddress(t *testing.T) {
+	tests := []struct {
+		macAddress string
+		message    string
+	}{
+		{"", ""},
+		{"52:54:00:12:34:56", ""},
+		{"52-54-00-12-34-56", ""},
+		{"52:54:00:12:34", "must be a 48-bit MAC address"},
+		{"52:54:00:12:34:56:78:9a", "must be a 48-bit MAC address"},
+		{"52:54:00:12:34:zz", "must be a 48-bit MAC address"},
+		{"01:00:5e:00:00:01", "must be a unicast MAC address"},
+		{"ff:ff:ff:ff:ff:ff", "must be a unicast MAC address"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateMACAddress("+tt.macAddress+")", ValidateMACAddress("field", tt.macAddress), tt.message)
+	}
+}
+
+func TestValidateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		message string
+	}{
+		{"", ""},
+		{"192.0.2.10", ""},
+		{"2001:db8::10", ""},
+		{"192.0.2.256", "must be a valid IP address"},
+		{"192.0.2.10/24", "must be a valid IP address"},
+		{"example.com", "must be a valid IP address"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateIP("+tt.ip+")", ValidateIP("field", tt.ip), tt.message)
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	tests := []struct {
+		port    int32
+		message string
+	}{
+		{1, ""},
+		{80, ""},
+		{65535, ""},
+		{0, "must be between 1 and 65535"},
+		{-1, "must be between 1 and 65535"},
+		{65536, "must be between 1 and 65535"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidatePort("+strconv.Itoa(int(tt.port))+")", ValidatePort("field", tt.port), tt.message)
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	tests := []struct {
+		protocol corev1.Protocol
+		message  string
+	}{
+		{"", ""},
+		{corev1.ProtocolTCP, ""},
+		{corev1.ProtocolUDP, ""},
+		{corev1.ProtocolSCTP, ""},
+		{"tcp", "must be one of TCP, UDP or SCTP"},
+		{"ICMP", "must be one of TCP, UDP or SCTP"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateProtocol("+string(tt.protocol)+")", ValidateProtocol("field", tt.protocol), tt.message)
+	}
+}
+
+func TestValidateRepository(t *testing.T) {
+	tests := []struct {
+		repository string
+		message    string
+	}{
+		{"https://cloud-images.ubuntu.com/focal/current/focal-server-cloudimg-amd64.img", ""},
+		{"http://192.0.2.10:8080/images/focal.qcow2", ""},
+		{"", "must not be empty"},
+		{"ftp://example.com/focal.img", "scheme must be http or https"},
+		{"example.com/focal.img", "scheme must be http or https"},
+		{"https:///focal.img", "must contain a host"},
+		{"https://example.com/%zz", "invalid URL"},
+	}
+
+	for _, tt := range tests {
+		check(t, "ValidateRepository("+tt.repository+")", ValidateRepository("field", tt.repository), tt.message)
+	}
+}